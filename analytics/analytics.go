@@ -4,93 +4,206 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
 )
 
 func ItemReport(db *sql.DB, id string) {
-	rows := database.QueryItemByID(db, id)
-	since := rows[len(rows)-1].Timestamp
+	report, err := Report(db, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(report)
+}
 
-	last_in_stock := "never"
-	last_out_of_stock := "never"
+// Stats summarizes an item's availability history.
+type Stats struct {
+	ID             string
+	Since          string
+	InStock        bool
+	LastInStock    string
+	LastOutOfStock string
+
+	TimesInStock    int
+	TimesOutOfStock int
+	TimeInStock     time.Duration
+	TimeOutOfStock  time.Duration
+	AvgInStock      time.Duration
+	AvgOutOfStock   time.Duration
+	// CurrentFor is how long the item has been in its current state
+	CurrentFor time.Duration
+	// PredictedChange is the time left until the current state has lasted
+	// as long as it does on average
+	PredictedChange time.Duration
+}
 
-	times_in_stock := 0
-	times_out_of_stock := 0
+// Interval is a stretch of time an item was in or out of stock.
+type Interval struct {
+	InStock bool
+	Start   time.Time
+	End     time.Time
+}
 
-	time_in_stock := 0.0
-	time_out_of_stock := 0.0
+type PricePoint struct {
+	Time  time.Time
+	Price money.Money
+}
+
+// Compute works out an item's stats from its stock rows, newest first.
+// ok is false if there are no rows.
+func Compute(id string, rows []database.StockRow, now time.Time) (stats Stats, ok bool, err error) {
+	rows = availabilityChanges(rows)
+	if len(rows) == 0 {
+		return Stats{ID: id}, false, nil
+	}
+	times, err := parseTimes(rows)
+	if err != nil {
+		return Stats{ID: id}, false, err
+	}
+	stats = Stats{
+		ID:             id,
+		Since:          rows[len(rows)-1].Timestamp,
+		InStock:        rows[0].InStock,
+		LastInStock:    "never",
+		LastOutOfStock: "never",
+	}
 
 	for i, r := range rows {
 		// 1. update last in/out of stock
-		if r.InStock && last_in_stock == "never" {
-			last_in_stock = r.Timestamp
-		} else if !r.InStock && last_out_of_stock == "never" {
-			last_out_of_stock = r.Timestamp
+		if r.InStock && stats.LastInStock == "never" {
+			stats.LastInStock = r.Timestamp
+		} else if !r.InStock && stats.LastOutOfStock == "never" {
+			stats.LastOutOfStock = r.Timestamp
 		}
 
 		// 2. update times in/out of stock
 		if r.InStock {
-			times_in_stock++
+			stats.TimesInStock++
 		} else {
-			times_out_of_stock++
+			stats.TimesOutOfStock++
 		}
 
 		// 3. update time in/out of stock
 		if i != 0 {
-			timeSince := parseTime(rows[i-1].Timestamp).Sub(parseTime(r.Timestamp))
+			timeSince := times[i-1].Sub(times[i])
 			if r.InStock {
-				time_in_stock += timeSince.Seconds()
+				stats.TimeInStock += timeSince
 			} else {
-				time_out_of_stock += timeSince.Seconds()
+				stats.TimeOutOfStock += timeSince
 			}
 		}
 	}
 	// update to present time
-	timeSince := time.Now().Sub(parseTime(rows[0].Timestamp))
+	stats.CurrentFor = now.Sub(times[0])
+	if rows[0].InStock {
+		stats.TimeInStock += stats.CurrentFor
+	} else {
+		stats.TimeOutOfStock += stats.CurrentFor
+	}
+
+	// 4. Average time in/out of stock, and predicted next change
+	if stats.TimesInStock > 0 {
+		stats.AvgInStock = stats.TimeInStock / time.Duration(stats.TimesInStock)
+	}
+	if stats.TimesOutOfStock > 0 {
+		stats.AvgOutOfStock = stats.TimeOutOfStock / time.Duration(stats.TimesOutOfStock)
+	}
 	if rows[0].InStock {
-		time_in_stock += timeSince.Seconds()
+		stats.PredictedChange = stats.AvgInStock - stats.CurrentFor
 	} else {
-		time_out_of_stock += timeSince.Seconds()
+		stats.PredictedChange = stats.AvgOutOfStock - stats.CurrentFor
+	}
+	return stats, true, nil
+}
+
+// Intervals returns an item's in and out of stock stretches, oldest first,
+// from its stock rows, newest first. The last runs until now.
+func Intervals(rows []database.StockRow, now time.Time) ([]Interval, error) {
+	rows = availabilityChanges(rows)
+	var intervals []Interval
+	end := now
+	for _, r := range rows {
+		start, err := parseTime(r.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		intervals = append([]Interval{{InStock: r.InStock, Start: start, End: end}}, intervals...)
+		end = start
 	}
+	return intervals, nil
+}
 
-	fmt.Printf("Printing report for \"%s\"\n", id)
-	fmt.Printf("Data since: %s\n", since)
-	fmt.Printf("In stock: %t\n", rows[0].InStock)
+// Prices returns an item's recorded prices, oldest first, from its stock
+// rows, newest first.
+func Prices(rows []database.StockRow) ([]PricePoint, error) {
+	var points []PricePoint
+	for n := len(rows) - 1; n >= 0; n-- {
+		if price := rows[n].Amount(); price.Valid() {
+			t, err := parseTime(rows[n].Timestamp)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, PricePoint{Time: t, Price: price})
+		}
+	}
+	return points, nil
+}
+
+// Report summarizes an item's stock history.
+func Report(db *sql.DB, id string) (string, error) {
+	stats, ok, err := Compute(id, database.QueryItemByID(db, id), time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return fmt.Sprintf("No stock history for \"%s\"\n", id), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Printing report for \"%s\"\n", id)
+	fmt.Fprintf(&b, "Data since: %s\n", stats.Since)
+	fmt.Fprintf(&b, "In stock: %t\n", stats.InStock)
 
 	// 1. Last in stock/Last out of stock
-	fmt.Printf("Last in stock: %s\n", last_in_stock)
-	fmt.Printf("Last out of stock: %s\n", last_out_of_stock)
+	fmt.Fprintf(&b, "Last in stock: %s\n", stats.LastInStock)
+	fmt.Fprintf(&b, "Last out of stock: %s\n", stats.LastOutOfStock)
 
 	// 2. Number of times in stock
-	fmt.Printf("Times in stock: %d\n", times_in_stock)
-	fmt.Printf("Times out of stock: %d\n", times_out_of_stock)
+	fmt.Fprintf(&b, "Times in stock: %d\n", stats.TimesInStock)
+	fmt.Fprintf(&b, "Times out of stock: %d\n", stats.TimesOutOfStock)
 
 	// 3. Average days in/out of stock
-	avg_in_stock := time_in_stock / float64(times_in_stock)
-	avg_out_of_stock := time_out_of_stock / float64(times_out_of_stock)
-	fmt.Printf("Time in stock: %s\n", formatSeconds(int(time_in_stock)))
-	fmt.Printf("Time out of stock: %s\n", formatSeconds(int(time_out_of_stock)))
-	if times_in_stock > 0 {
-		fmt.Printf("Avg time in stock: %s\n", formatSeconds(int(time_in_stock)/times_in_stock))
+	fmt.Fprintf(&b, "Time in stock: %s\n", FormatDuration(stats.TimeInStock))
+	fmt.Fprintf(&b, "Time out of stock: %s\n", FormatDuration(stats.TimeOutOfStock))
+	if stats.TimesInStock > 0 {
+		fmt.Fprintf(&b, "Avg time in stock: %s\n", FormatDuration(stats.AvgInStock))
 	}
-	if times_out_of_stock > 0 {
-		fmt.Printf("Avg time out of stock: %s\n", formatSeconds(int(time_out_of_stock)/times_out_of_stock))
+	if stats.TimesOutOfStock > 0 {
+		fmt.Fprintf(&b, "Avg time out of stock: %s\n", FormatDuration(stats.AvgOutOfStock))
 	}
 
 	// 4. Predicted next in/out of stock
-	var predicted float64
-	if rows[0].InStock {
-		predicted = avg_in_stock - timeSince.Seconds()
-	} else {
-		predicted = avg_out_of_stock - timeSince.Seconds()
+	fmt.Fprintf(&b, "Predicted next stock change: %s\n", FormatDuration(stats.PredictedChange))
+	return b.String(), nil
+}
+
+// availabilityChanges drops rows, newest first, that only record a price
+// change.
+func availabilityChanges(rows []database.StockRow) []database.StockRow {
+	var changes []database.StockRow
+	for i, r := range rows {
+		if i == len(rows)-1 || r.InStock != rows[i+1].InStock {
+			changes = append(changes, r)
+		}
 	}
-	fmt.Printf("Predicted next stock change: %s\n", formatSeconds(int(predicted)))
+	return changes
 }
 
-func formatSeconds(s int) string {
-	// Converts seconds to a xhyy format
+// FormatDuration formats a duration like "2d 3h15"
+func FormatDuration(d time.Duration) string {
+	s := int(d.Seconds())
 	secs_per_day := 24 * 60 * 60
 	days := s / secs_per_day
 	hours := (s % secs_per_day) / 3600
@@ -102,10 +215,23 @@ func formatSeconds(s int) string {
 	return msg
 }
 
-func parseTime(s string) time.Time {
-	t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+func parseTime(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(database.TimeFormat, s, time.Local)
 	if err != nil {
-		log.Fatal(err)
+		return time.Time{}, fmt.Errorf("bad stock timestamp %q: %s", s, err)
+	}
+	return t, nil
+}
+
+// parseTimes parses the timestamps of rows
+func parseTimes(rows []database.StockRow) ([]time.Time, error) {
+	times := make([]time.Time, len(rows))
+	for n, r := range rows {
+		t, err := parseTime(r.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		times[n] = t
 	}
-	return t
+	return times, nil
 }
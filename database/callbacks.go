@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+func createCallbackTables(db *sql.DB) {
+	sql_tables := `
+    CREATE TABLE IF NOT EXISTS callback_refs(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        ChatID INTEGER NOT NULL,
+        ItemID TEXT NOT NULL,
+        UNIQUE(ChatID, ItemID)
+    );
+    CREATE TABLE IF NOT EXISTS mutes(
+        ChatID INTEGER NOT NULL,
+        ItemID TEXT NOT NULL,
+        Until DATETIME NOT NULL,
+        PRIMARY KEY (ChatID, ItemID)
+    );`
+	if _, err := db.Exec(sql_tables); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// CallbackRef returns a short reference to a chat's item, for telegram
+// callback data, which is limited to 64 bytes.
+func CallbackRef(db *sql.DB, chat_id int64, item_id string) int64 {
+	q := "INSERT OR IGNORE INTO callback_refs(ChatID, ItemID) VALUES (?, ?);"
+	if _, err := db.Exec(q, chat_id, item_id); err != nil {
+		log.Fatal(err)
+	}
+	var id int64
+	q = "SELECT ID FROM callback_refs WHERE ChatID = ? AND ItemID = ?;"
+	if err := db.QueryRow(q, chat_id, item_id).Scan(&id); err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+// CallbackItem returns the item a reference is to, if it belongs to the
+// chat.
+func CallbackItem(db *sql.DB, chat_id int64, ref int64) (string, bool) {
+	var item_id string
+	q := "SELECT ItemID FROM callback_refs WHERE ID = ? AND ChatID = ?;"
+	err := db.QueryRow(q, ref, chat_id).Scan(&item_id)
+	if err == sql.ErrNoRows {
+		return "", false
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	return item_id, true
+}
+
+// Mute stops alerts to a chat about an item until a time.
+func Mute(db *sql.DB, chat_id int64, item_id string, until time.Time) {
+	q := "INSERT OR REPLACE INTO mutes(ChatID, ItemID, Until) VALUES (?, ?, ?);"
+	if _, err := db.Exec(q, chat_id, item_id, until.UTC().Format(TimeFormat)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// MutedItems returns the IDs of items a chat has muted at t.
+func MutedItems(db *sql.DB, chat_id int64, t time.Time) map[string]bool {
+	q := "SELECT ItemID FROM mutes WHERE ChatID = ? AND Until > ?;"
+	rows, err := db.Query(q, chat_id, t.UTC().Format(TimeFormat))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	muted := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		muted[id] = true
+	}
+	return muted
+}
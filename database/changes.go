@@ -0,0 +1,75 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// StockChange is a stock row with the state of the item's row before it.
+type StockChange struct {
+	StockRow
+	// PrevInStock is unset for an item's first row
+	PrevInStock sql.NullBool
+	PrevPrice   string
+}
+
+func (c StockChange) Restocked() bool {
+	return c.PrevInStock.Valid && !c.PrevInStock.Bool && c.InStock
+}
+
+func (c StockChange) SoldOut() bool {
+	return c.PrevInStock.Valid && c.PrevInStock.Bool && !c.InStock
+}
+
+func (c StockChange) PriceChanged() bool {
+	return c.PrevInStock.Valid && c.PrevPrice != "" && c.Price != "" && c.PrevPrice != c.Price
+}
+
+// StockChanges returns the stock rows recorded since t, oldest first,
+// each with the row before it.
+func StockChanges(db *sql.DB, since time.Time) []StockChange {
+	q := `
+    SELECT ProductName, ItemName, Price, PriceCents, MaxPriceCents, WasPriceCents, Currency,
+        InStock, DATETIME(Timestamp, 'localtime'), PrevInStock, PrevPrice
+    FROM (
+        SELECT ID, ProductName, ItemName, COALESCE(Price, '') AS Price,
+            COALESCE(PriceCents, 0) AS PriceCents, COALESCE(MaxPriceCents, 0) AS MaxPriceCents,
+            COALESCE(WasPriceCents, 0) AS WasPriceCents, COALESCE(Currency, '') AS Currency,
+            InStock, Timestamp,
+            LAG(InStock) OVER item_rows AS PrevInStock,
+            COALESCE(LAG(Price) OVER item_rows, '') AS PrevPrice
+        FROM stock
+        WINDOW item_rows AS (PARTITION BY ProductName, ItemName ORDER BY Timestamp, ID)
+    )
+    WHERE Timestamp >= ?
+    ORDER BY Timestamp, ID;`
+	rows, err := db.Query(q, since.UTC().Format(TimeFormat))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var changes []StockChange
+	for rows.Next() {
+		c := StockChange{}
+		err := rows.Scan(
+			&c.ProductName,
+			&c.ItemName,
+			&c.Price,
+			&c.PriceCents,
+			&c.MaxPriceCents,
+			&c.WasPriceCents,
+			&c.Currency,
+			&c.InStock,
+			&c.Timestamp,
+			&c.PrevInStock,
+			&c.PrevPrice,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
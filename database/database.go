@@ -2,29 +2,76 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
 )
 
 type StockRow struct {
-	ProductName string
-	ItemName    string
-	Price       string
-	InStock     bool
-	Timestamp   string
+	ProductName   string
+	ItemName      string
+	Price         string
+	PriceCents    int64
+	MaxPriceCents int64
+	WasPriceCents int64
+	Currency      string
+	InStock       bool
+	Timestamp     string
 }
 
 func (r StockRow) ID() string {
 	return r.ProductName + ": " + r.ItemName
 }
 
+// Amount is the row's price, invalid if it was not recorded.
+func (r StockRow) Amount() money.Money {
+	if r.Currency == "" {
+		return money.Money{}
+	}
+	return money.Money{Cents: r.PriceCents, Currency: r.Currency}
+}
+
+// Item rebuilds the item a row was recorded from.
+func (r StockRow) Item(p *product.Product) item.Item {
+	i := item.Item{
+		Product:      p,
+		Name:         r.ItemName,
+		Price:        r.Amount(),
+		Availability: "Out of stock",
+	}
+	if r.InStock {
+		i.Availability = "In stock"
+	}
+	if r.Currency != "" && r.MaxPriceCents != 0 {
+		i.MaxPrice = money.Money{Cents: r.MaxPriceCents, Currency: r.Currency}
+	}
+	if r.Currency != "" && r.WasPriceCents != 0 {
+		i.WasPrice = money.Money{Cents: r.WasPriceCents, Currency: r.Currency}
+	}
+	return i
+}
+
 func Setup() *sql.DB {
 	db := connect("db.sqlite")
 	createTable(db)
+	createStateTables(db)
+	createWatchTable(db)
+	createSubscriberTable(db)
+	createRunTables(db)
+	createEventTable(db)
+	createDeliveryTable(db)
+	createQueueTable(db)
+	createSentTable(db)
+	createObservationTable(db)
+	createNotificationTable(db)
+	createCallbackTables(db)
+	migratePrices(db)
 	return db
 }
 
@@ -32,14 +79,45 @@ func UpdateStock(db *sql.DB, items []item.Item) {
 	rows := queryLatestStock(db)
 	for _, i := range items {
 		r, ok := rows[i.ID()]
+		e := Event{
+			ProductName: i.Product.Name,
+			ItemName:    i.Name,
+			NewInStock:  i.IsAvailable(),
+			NewPrice:    i.PriceString(),
+		}
+		if ok {
+			e.OldInStock = sql.NullBool{Bool: r.InStock, Valid: true}
+			e.OldPrice = r.Price
+		}
 		if ok && i.IsAvailable() != r.InStock {
 			// Insert row when availability is mismatched
 			InsertStockRow(db, i)
+			e.Type = EventOutOfStock
+			if i.IsAvailable() {
+				e.Type = EventInStock
+			}
+		} else if ok && i.Price.Valid() && i.Price != r.Amount() {
+			// Insert row when the price changed, keeping price history
+			InsertStockRow(db, i)
+			e.Type = EventPrice
 		} else if !ok {
 			// Insert new items, not yet in db
 			InsertStockRow(db, i)
+			e.Type = EventNew
+		} else {
+			continue
 		}
+		insertEvent(db, e)
+	}
+}
+
+// LatestPrices returns the last recorded price of every item.
+func LatestPrices(db *sql.DB) map[string]money.Money {
+	prices := map[string]money.Money{}
+	for id, r := range queryLatestStock(db) {
+		prices[id] = r.Amount()
 	}
+	return prices
 }
 
 func InsertStockRow(db *sql.DB, i item.Item) {
@@ -48,35 +126,60 @@ func InsertStockRow(db *sql.DB, i item.Item) {
         ProductName,
         ItemName,
         Price,
+        PriceCents,
+        MaxPriceCents,
+        WasPriceCents,
+        Currency,
         InStock
-    ) values (?, ?, ?, ?);`
+    ) values (?, ?, ?, ?, ?, ?, ?, ?);`
 	stmt, err := db.Prepare(q)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(i.Product.Name, i.Name, i.Price, i.IsAvailable())
+	_, err = stmt.Exec(
+		i.Product.Name,
+		i.Name,
+		i.PriceString(),
+		nullCents(i.Price),
+		nullCents(i.MaxPrice),
+		nullCents(i.WasPrice),
+		nullCurrency(i.Price),
+		i.IsAvailable(),
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func QueryItemByID(db *sql.DB, id string) []StockRow {
-	id_parts := strings.Split(id, ": ")
+	id_parts := strings.SplitN(id, ": ", 2)
+	if len(id_parts) != 2 {
+		return nil
+	}
 	q := `
-    SELECT ProductName, ItemName, Price, InStock, DATETIME(Timestamp, 'localtime')
+    SELECT ` + stockColumns + `
     FROM stock
     WHERE ProductName = ? and ItemName = ?
-    ORDER BY Timestamp DESC;`
+    ORDER BY Timestamp DESC, ID DESC;`
 	return queryStock(db, q, id_parts[0], id_parts[1])
 }
 
+const stockColumns = `ProductName, ItemName, COALESCE(Price, ''),
+        COALESCE(PriceCents, 0), COALESCE(MaxPriceCents, 0), COALESCE(WasPriceCents, 0),
+        COALESCE(Currency, ''), InStock, DATETIME(Timestamp, 'localtime')`
+
+// LatestStock returns the most recent row of every item.
+func LatestStock(db *sql.DB) map[string]StockRow {
+	return queryLatestStock(db)
+}
+
 func queryLatestStock(db *sql.DB) map[string]StockRow {
 	q := `
-    SELECT ProductName, ItemName, Price, InStock, DATETIME(Timestamp, 'localtime')
+    SELECT ` + stockColumns + `
     FROM stock
-    ORDER BY Timestamp DESC;`
+    ORDER BY Timestamp DESC, ID DESC;`
 
 	rows := queryStock(db, q)
 	m := map[string]StockRow{}
@@ -113,6 +216,10 @@ func queryStock(db *sql.DB, q string, parameters ...interface{}) []StockRow {
 			&stock_row.ProductName,
 			&stock_row.ItemName,
 			&stock_row.Price,
+			&stock_row.PriceCents,
+			&stock_row.MaxPriceCents,
+			&stock_row.WasPriceCents,
+			&stock_row.Currency,
 			&stock_row.InStock,
 			&stock_row.Timestamp,
 		)
@@ -137,4 +244,84 @@ func createTable(db *sql.DB) {
 	if _, err := db.Exec(sql_table); err != nil {
 		log.Fatal(err)
 	}
+	addColumn(db, "stock", "PriceCents", "INTEGER")
+	addColumn(db, "stock", "MaxPriceCents", "INTEGER")
+	addColumn(db, "stock", "WasPriceCents", "INTEGER")
+	addColumn(db, "stock", "Currency", "TEXT")
+}
+
+// addColumn adds a column to an existing table if it is missing.
+func addColumn(db *sql.DB, table, column, decl string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, col_type string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &col_type, &notnull, &dflt, &pk); err != nil {
+			log.Fatal(err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+	q := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, decl)
+	if _, err := db.Exec(q); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migratePrices fills in the numeric price columns of rows recorded
+// before they existed, from the price text. Rows whose price cannot be
+// parsed get an empty Currency, so they are only tried once.
+func migratePrices(db *sql.DB) {
+	rows, err := db.Query("SELECT ID, Price FROM stock WHERE Currency IS NULL AND Price != '';")
+	if err != nil {
+		log.Fatal(err)
+	}
+	prices := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var price string
+		if err := rows.Scan(&id, &price); err != nil {
+			log.Fatal(err)
+		}
+		prices[id] = price
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for id, price := range prices {
+		low, high, err := money.ParseRange(price)
+		if err != nil {
+			if _, err := tx.Exec("UPDATE stock SET Currency = '' WHERE ID = ?;", id); err != nil {
+				tx.Rollback()
+				log.Fatal(err)
+			}
+			continue
+		}
+		q := "UPDATE stock SET PriceCents = ?, MaxPriceCents = ?, Currency = ? WHERE ID = ?;"
+		if _, err := tx.Exec(q, nullCents(low), nullCents(high), low.Currency, id); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func nullCents(m money.Money) sql.NullInt64 {
+	return sql.NullInt64{Int64: m.Cents, Valid: m.Valid()}
+}
+
+func nullCurrency(m money.Money) sql.NullString {
+	return sql.NullString{String: m.Currency, Valid: m.Valid()}
 }
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+type Delivery struct {
+	ID       int64
+	Notifier string
+	Target   string
+	// Error is empty if the delivery succeeded
+	Error     string
+	Timestamp string
+}
+
+func createDeliveryTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS deliveries(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        Notifier TEXT NOT NULL,
+        Target TEXT NOT NULL,
+        Error TEXT NOT NULL,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func LogDelivery(db *sql.DB, d Delivery) {
+	q := "INSERT INTO deliveries(Notifier, Target, Error) VALUES (?, ?, ?);"
+	if _, err := db.Exec(q, d.Notifier, d.Target, d.Error); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Deliveries returns the most recent deliveries, newest first.
+func Deliveries(db *sql.DB, limit int) []Delivery {
+	q := `
+    SELECT ID, Notifier, Target, Error, DATETIME(Timestamp, 'localtime')
+    FROM deliveries
+    ORDER BY ID DESC
+    LIMIT ?;`
+	rows, err := db.Query(q, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		d := Delivery{}
+		if err := rows.Scan(&d.ID, &d.Notifier, &d.Target, &d.Error, &d.Timestamp); err != nil {
+			log.Fatal(err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// Event types
+const (
+	EventNew        = "new"
+	EventInStock    = "in_stock"
+	EventOutOfStock = "out_of_stock"
+	EventPrice      = "price"
+)
+
+// Event is a transition recorded by UpdateStock. The old state is unset
+// for new items.
+type Event struct {
+	ID          int64
+	Type        string
+	ProductName string
+	ItemName    string
+	OldInStock  sql.NullBool
+	NewInStock  bool
+	OldPrice    string
+	NewPrice    string
+	Timestamp   string
+}
+
+func (e Event) ItemID() string {
+	return e.ProductName + ": " + e.ItemName
+}
+
+func createEventTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS events(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        Type TEXT NOT NULL,
+        ProductName TEXT NOT NULL,
+        ItemName TEXT NOT NULL,
+        OldInStock INTEGER,
+        NewInStock INTEGER NOT NULL,
+        OldPrice TEXT NOT NULL,
+        NewPrice TEXT NOT NULL,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func insertEvent(db *sql.DB, e Event) {
+	q := `
+    INSERT INTO events(Type, ProductName, ItemName, OldInStock, NewInStock, OldPrice, NewPrice)
+    VALUES (?, ?, ?, ?, ?, ?, ?);`
+	_, err := db.Exec(q, e.Type, e.ProductName, e.ItemName, e.OldInStock, e.NewInStock, e.OldPrice, e.NewPrice)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// EventsSince returns up to limit events after the given ID, oldest first.
+func EventsSince(db *sql.DB, after_id int64, limit int) []Event {
+	q := `
+    SELECT ID, Type, ProductName, ItemName, OldInStock, NewInStock, OldPrice, NewPrice,
+        DATETIME(Timestamp, 'localtime')
+    FROM events
+    WHERE ID > ?
+    ORDER BY ID
+    LIMIT ?;`
+	rows, err := db.Query(q, after_id, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		e := Event{}
+		err := rows.Scan(
+			&e.ID,
+			&e.Type,
+			&e.ProductName,
+			&e.ItemName,
+			&e.OldInStock,
+			&e.NewInStock,
+			&e.OldPrice,
+			&e.NewPrice,
+			&e.Timestamp,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// LastEventID returns the ID of the newest event, or 0 if there are none.
+func LastEventID(db *sql.DB) int64 {
+	var id int64
+	if err := db.QueryRow("SELECT COALESCE(MAX(ID), 0) FROM events;").Scan(&id); err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Notification kinds
+const (
+	NotifiedInStock = "in_stock"
+	NotifiedPrice   = "price"
+	NotifiedSoldOut = "sold_out"
+)
+
+type Notification struct {
+	ID        int64
+	ChatID    int64
+	ItemID    string
+	Kind      string
+	Timestamp string
+}
+
+func createNotificationTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS notification_log(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        ChatID INTEGER NOT NULL,
+        ItemID TEXT NOT NULL,
+        Kind TEXT NOT NULL,
+        Timestamp DATETIME NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS notification_log_chat ON notification_log(ChatID, Kind, ItemID);`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LogNotifications records that a chat was notified of items at t.
+func LogNotifications(db *sql.DB, chat_id int64, kind string, ids []string, t time.Time) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	q := "INSERT INTO notification_log(ChatID, ItemID, Kind, Timestamp) VALUES (?, ?, ?, ?);"
+	for _, id := range ids {
+		if _, err := tx.Exec(q, chat_id, id, kind, t.UTC().Format(TimeFormat)); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// LastNotified returns when a chat was last notified of each item, for a
+// kind of notification.
+func LastNotified(db *sql.DB, chat_id int64, kind string) map[string]time.Time {
+	q := `
+    SELECT ItemID, MAX(Timestamp)
+    FROM notification_log
+    WHERE ChatID = ? AND Kind = ?
+    GROUP BY ItemID;`
+	rows, err := db.Query(q, chat_id, kind)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	last := map[string]time.Time{}
+	for rows.Next() {
+		var id, timestamp string
+		if err := rows.Scan(&id, &timestamp); err != nil {
+			log.Fatal(err)
+		}
+		t, err := time.Parse(TimeFormat, timestamp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		last[id] = t
+	}
+	return last
+}
+
+// Notifications returns the most recent notifications, newest first.
+func Notifications(db *sql.DB, limit int) []Notification {
+	q := `
+    SELECT ID, ChatID, ItemID, Kind, DATETIME(Timestamp, 'localtime')
+    FROM notification_log
+    ORDER BY ID DESC
+    LIMIT ?;`
+	rows, err := db.Query(q, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		n := Notification{}
+		if err := rows.Scan(&n.ID, &n.ChatID, &n.ItemID, &n.Kind, &n.Timestamp); err != nil {
+			log.Fatal(err)
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+func createObservationTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS observations(
+        ItemID TEXT PRIMARY KEY,
+        InStock INTEGER NOT NULL,
+        Streak INTEGER NOT NULL,
+        Confirmed INTEGER,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Observe records whether each item was seen in stock this run, and
+// returns the confirmed stock of observed items. A change is only
+// confirmed once seen in confirmations consecutive runs, so items flapping
+// between scrapes keep their confirmed state. New items are left out until
+// their stock is confirmed. Items missing from this run keep their streak.
+func Observe(db *sql.DB, in_stock map[string]bool, confirmations int) map[string]bool {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	q := `
+    INSERT INTO observations(ItemID, InStock, Streak, Confirmed)
+    VALUES (?1, ?2, 1, CASE WHEN ?3 <= 1 THEN ?2 END)
+    ON CONFLICT(ItemID) DO UPDATE SET
+        Streak = CASE WHEN InStock = ?2 THEN Streak + 1 ELSE 1 END,
+        InStock = ?2,
+        Confirmed = CASE
+            WHEN (CASE WHEN InStock = ?2 THEN Streak + 1 ELSE 1 END) >= ?3 THEN ?2
+            ELSE Confirmed
+        END,
+        Timestamp = CURRENT_TIMESTAMP;`
+	for id, available := range in_stock {
+		if _, err := tx.Exec(q, id, available, confirmations); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT ItemID, Confirmed FROM observations WHERE Confirmed IS NOT NULL;")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	confirmed := map[string]bool{}
+	for rows.Next() {
+		var id string
+		var c bool
+		if err := rows.Scan(&id, &c); err != nil {
+			log.Fatal(err)
+		}
+		confirmed[id] = c
+	}
+	return confirmed
+}
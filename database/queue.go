@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+// QueuedAlert is a message held back during a chat's quiet hours.
+type QueuedAlert struct {
+	ID        int64
+	ChatID    int64
+	Message   string
+	Timestamp string
+}
+
+func createQueueTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS queued_alerts(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        ChatID INTEGER NOT NULL,
+        Message TEXT NOT NULL,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func QueueAlert(db *sql.DB, chat_id int64, msg string) {
+	if _, err := db.Exec("INSERT INTO queued_alerts(ChatID, Message) VALUES (?, ?);", chat_id, msg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// QueuedAlerts returns a chat's queued messages, oldest first.
+func QueuedAlerts(db *sql.DB, chat_id int64) []QueuedAlert {
+	q := `
+    SELECT ID, ChatID, Message, DATETIME(Timestamp, 'localtime')
+    FROM queued_alerts
+    WHERE ChatID = ?
+    ORDER BY ID;`
+	rows, err := db.Query(q, chat_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var alerts []QueuedAlert
+	for rows.Next() {
+		a := QueuedAlert{}
+		if err := rows.Scan(&a.ID, &a.ChatID, &a.Message, &a.Timestamp); err != nil {
+			log.Fatal(err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+// ClearQueuedAlerts deletes a chat's queued messages up to and including
+// up_to_id, leaving any queued since they were read.
+func ClearQueuedAlerts(db *sql.DB, chat_id int64, up_to_id int64) {
+	if _, err := db.Exec("DELETE FROM queued_alerts WHERE ChatID = ? AND ID <= ?;", chat_id, up_to_id); err != nil {
+		log.Fatal(err)
+	}
+}
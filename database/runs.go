@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+type Run struct {
+	ID        int64
+	Started   string
+	Duration  float64
+	Attempted int
+	Succeeded int
+	Failed    int
+	Items     int
+	Products  []RunProduct
+}
+
+type RunProduct struct {
+	ProductName string
+	Brand       string
+	Category    string
+	Items       int
+	Error       string
+	Duration    float64
+	// Anomaly is the kind of health anomaly found, if any
+	Anomaly string
+}
+
+func createRunTables(db *sql.DB) {
+	sql_tables := `
+    CREATE TABLE IF NOT EXISTS runs(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        Started DATETIME NOT NULL,
+        Duration REAL NOT NULL,
+        Attempted INTEGER NOT NULL,
+        Succeeded INTEGER NOT NULL,
+        Failed INTEGER NOT NULL,
+        Items INTEGER NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS run_products(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        RunID INTEGER NOT NULL REFERENCES runs(ID),
+        ProductName TEXT NOT NULL,
+        Brand TEXT NOT NULL,
+        Category TEXT NOT NULL,
+        Items INTEGER NOT NULL,
+        Error TEXT NOT NULL,
+        Duration REAL NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS run_products_product ON run_products(ProductName, RunID);`
+	if _, err := db.Exec(sql_tables); err != nil {
+		log.Fatal(err)
+	}
+	addColumn(db, "run_products", "Anomaly", "TEXT NOT NULL DEFAULT ''")
+}
+
+// SaveRun stores a run and its products, returning the run's ID.
+func SaveRun(db *sql.DB, started time.Time, r Run) int64 {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	q := `
+    INSERT INTO runs(Started, Duration, Attempted, Succeeded, Failed, Items)
+    VALUES (?, ?, ?, ?, ?, ?);`
+	res, err := tx.Exec(q, started.UTC().Format(TimeFormat), r.Duration, r.Attempted, r.Succeeded, r.Failed, r.Items)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	run_id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+
+	q = `
+    INSERT INTO run_products(RunID, ProductName, Brand, Category, Items, Error, Duration, Anomaly)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+	for _, p := range r.Products {
+		_, err := tx.Exec(q, run_id, p.ProductName, p.Brand, p.Category, p.Items, p.Error, p.Duration, p.Anomaly)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	return run_id
+}
+
+// Runs returns the most recent runs, newest first, without their products.
+func Runs(db *sql.DB, limit int) []Run {
+	q := `
+    SELECT ID, DATETIME(Started, 'localtime'), Duration, Attempted, Succeeded, Failed, Items
+    FROM runs
+    ORDER BY ID DESC
+    LIMIT ?;`
+	rows, err := db.Query(q, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		r := Run{}
+		err := rows.Scan(&r.ID, &r.Started, &r.Duration, &r.Attempted, &r.Succeeded, &r.Failed, &r.Items)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runs = append(runs, r)
+	}
+	return runs
+}
+
+func RunProducts(db *sql.DB, run_id int64) []RunProduct {
+	q := `
+    SELECT ` + runProductColumns + `
+    FROM run_products
+    WHERE RunID = ?
+    ORDER BY ID;`
+	return queryRunProducts(db, q, run_id)
+}
+
+// LastRunProduct returns the most recent run of a product.
+func LastRunProduct(db *sql.DB, product_name string) (RunProduct, bool) {
+	q := `
+    SELECT ` + runProductColumns + `
+    FROM run_products
+    WHERE ProductName = ?
+    ORDER BY RunID DESC
+    LIMIT 1;`
+	products := queryRunProducts(db, q, product_name)
+	if len(products) == 0 {
+		return RunProduct{}, false
+	}
+	return products[0], true
+}
+
+// LastHealthyRunProduct returns the most recent run of a product that had
+// no error or anomaly.
+func LastHealthyRunProduct(db *sql.DB, product_name string) (RunProduct, bool) {
+	q := `
+    SELECT ` + runProductColumns + `
+    FROM run_products
+    WHERE ProductName = ? AND Error = '' AND Anomaly = ''
+    ORDER BY RunID DESC
+    LIMIT 1;`
+	products := queryRunProducts(db, q, product_name)
+	if len(products) == 0 {
+		return RunProduct{}, false
+	}
+	return products[0], true
+}
+
+const runProductColumns = "ProductName, Brand, Category, Items, Error, Duration, Anomaly"
+
+func queryRunProducts(db *sql.DB, q string, parameters ...interface{}) []RunProduct {
+	rows, err := db.Query(q, parameters...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var products []RunProduct
+	for rows.Next() {
+		p := RunProduct{}
+		err := rows.Scan(&p.ProductName, &p.Brand, &p.Category, &p.Items, &p.Error, &p.Duration, &p.Anomaly)
+		if err != nil {
+			log.Fatal(err)
+		}
+		products = append(products, p)
+	}
+	return products
+}
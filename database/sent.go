@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// SentMessage is the telegram message that told a chat an item was in
+// stock, kept for follow-ups when it sells out.
+type SentMessage struct {
+	ChatID    int64
+	ItemID    string
+	MessageID int64
+	// Text is the whole message, and Line the item's line in it
+	Text    string
+	Line    string
+	SoldOut bool
+	Sent    time.Time
+	// Keyboard is the message's inline keyboard as json, kept on edits
+	Keyboard string
+}
+
+func createSentTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS sent_messages(
+        ChatID INTEGER NOT NULL,
+        ItemID TEXT NOT NULL,
+        MessageID INTEGER NOT NULL,
+        Text TEXT NOT NULL,
+        Line TEXT NOT NULL,
+        SoldOut INTEGER NOT NULL DEFAULT 0,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (ChatID, ItemID)
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+	addColumn(db, "sent_messages", "Keyboard", "TEXT NOT NULL DEFAULT ''")
+}
+
+// SaveSentMessage records the message about an item, replacing any
+// earlier one.
+func SaveSentMessage(db *sql.DB, m SentMessage) {
+	q := `
+    INSERT OR REPLACE INTO sent_messages(ChatID, ItemID, MessageID, Text, Line, Keyboard)
+    VALUES (?, ?, ?, ?, ?, ?);`
+	if _, err := db.Exec(q, m.ChatID, m.ItemID, m.MessageID, m.Text, m.Line, m.Keyboard); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SentMessageFor returns the last message a chat got about an item.
+func SentMessageFor(db *sql.DB, chat_id int64, item_id string) (SentMessage, bool) {
+	q := `
+    SELECT ChatID, ItemID, MessageID, Text, Line, SoldOut, Timestamp, Keyboard
+    FROM sent_messages
+    WHERE ChatID = ? AND ItemID = ?;`
+	messages := querySentMessages(db, q, chat_id, item_id)
+	if len(messages) == 0 {
+		return SentMessage{}, false
+	}
+	return messages[0], true
+}
+
+// MessageItems returns the items a message was about.
+func MessageItems(db *sql.DB, chat_id int64, message_id int64) []SentMessage {
+	q := `
+    SELECT ChatID, ItemID, MessageID, Text, Line, SoldOut, Timestamp, Keyboard
+    FROM sent_messages
+    WHERE ChatID = ? AND MessageID = ?;`
+	return querySentMessages(db, q, chat_id, message_id)
+}
+
+func MarkSoldOut(db *sql.DB, chat_id int64, item_id string) {
+	q := "UPDATE sent_messages SET SoldOut = 1 WHERE ChatID = ? AND ItemID = ?;"
+	if _, err := db.Exec(q, chat_id, item_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func querySentMessages(db *sql.DB, q string, parameters ...interface{}) []SentMessage {
+	rows, err := db.Query(q, parameters...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var messages []SentMessage
+	for rows.Next() {
+		m := SentMessage{}
+		err := rows.Scan(&m.ChatID, &m.ItemID, &m.MessageID, &m.Text, &m.Line, &m.SoldOut, &m.Sent, &m.Keyboard)
+		if err != nil {
+			log.Fatal(err)
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TimeFormat matches sqlite's CURRENT_TIMESTAMP, in UTC.
+const TimeFormat = "2006-01-02 15:04:05"
+
+func createStateTables(db *sql.DB) {
+	sql_tables := `
+    CREATE TABLE IF NOT EXISTS notified(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        ItemID TEXT NOT NULL,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE TABLE IF NOT EXISTS last_in_stock(
+        ItemID TEXT PRIMARY KEY,
+        Timestamp DATETIME NOT NULL
+    );`
+	if _, err := db.Exec(sql_tables); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NotifiedItems returns the IDs of items a chat was already notified
+// about, so as to not re-notify.
+func NotifiedItems(db *sql.DB, chat_id int64) map[string]bool {
+	rows, err := db.Query("SELECT ItemID FROM notified WHERE ChatID = ?;", chat_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	notified := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Fatal(err)
+		}
+		notified[id] = true
+	}
+	return notified
+}
+
+// LastInStock returns when each item was last seen in stock.
+func LastInStock(db *sql.DB) map[string]time.Time {
+	rows, err := db.Query("SELECT ItemID, Timestamp FROM last_in_stock;")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	last_in_stock := map[string]time.Time{}
+	for rows.Next() {
+		var id string
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			log.Fatal(err)
+		}
+		last_in_stock[id] = t
+	}
+	return last_in_stock
+}
+
+// SaveRunState records a run in one transaction: each chat's notified
+// items become exactly its watched items in stock, keeping the original
+// timestamps of items that stayed in stock, and in_stock items are marked
+// as seen at t.
+func SaveRunState(db *sql.DB, notified map[int64][]string, in_stock []string, t time.Time) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for chat_id, ids := range notified {
+		saveNotified(tx, chat_id, ids)
+	}
+	saveLastInStock(tx, in_stock, t)
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ImportState loads state kept by older versions for a chat, without
+// overwriting anything already in the db.
+func ImportState(db *sql.DB, chat_id int64, notified []string, last_in_stock map[string]time.Time) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, id := range notified {
+		q := `
+        INSERT INTO notified(ChatID, ItemID)
+        SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM notified WHERE ChatID = ? AND ItemID = ?);`
+		if _, err := tx.Exec(q, chat_id, id, chat_id, id); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	for id, t := range last_in_stock {
+		q := "INSERT OR IGNORE INTO last_in_stock(ItemID, Timestamp) VALUES (?, ?);"
+		if _, err := tx.Exec(q, id, t.UTC().Format(TimeFormat)); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func saveNotified(tx *sql.Tx, chat_id int64, ids []string) {
+	if _, err := tx.Exec("CREATE TEMP TABLE IF NOT EXISTS current_notified(ItemID TEXT PRIMARY KEY);"); err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	if _, err := tx.Exec("DELETE FROM current_notified;"); err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO current_notified(ItemID) VALUES (?);", id); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	qs := []string{
+		"DELETE FROM notified WHERE ChatID = ?1 AND ItemID NOT IN (SELECT ItemID FROM current_notified);",
+		`INSERT INTO notified(ChatID, ItemID)
+        SELECT ?1, ItemID FROM current_notified
+        WHERE ItemID NOT IN (SELECT ItemID FROM notified WHERE ChatID = ?1);`,
+	}
+	for _, q := range qs {
+		if _, err := tx.Exec(q, chat_id); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+}
+
+func saveLastInStock(tx *sql.Tx, ids []string, t time.Time) {
+	q := "INSERT OR REPLACE INTO last_in_stock(ItemID, Timestamp) VALUES (?, ?);"
+	for _, id := range ids {
+		if _, err := tx.Exec(q, id, t.UTC().Format(TimeFormat)); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+}
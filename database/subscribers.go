@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+func createSubscriberTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS subscribers(
+        ChatID INTEGER PRIMARY KEY,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+	// Watches and notifications from before subscribers existed belong to
+	// chat 0 until claimed by ClaimUnowned
+	addColumn(db, "watches", "ChatID", "INTEGER NOT NULL DEFAULT 0")
+	addColumn(db, "notified", "ChatID", "INTEGER NOT NULL DEFAULT 0")
+
+	addColumn(db, "subscribers", "Quiet", "TEXT NOT NULL DEFAULT ''")
+	addColumn(db, "subscribers", "Digest", "TEXT NOT NULL DEFAULT ''")
+	addColumn(db, "subscribers", "LastDigest", "DATETIME")
+	addColumn(db, "subscribers", "FollowUps", "TEXT NOT NULL DEFAULT ''")
+	// Unsubscribed chats keep their row, so the default chat is not
+	// subscribed again on the next start
+	addColumn(db, "subscribers", "Unsubscribed", "INTEGER NOT NULL DEFAULT 0")
+}
+
+// Digest periods
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Follow-up modes, for when a notified item sells out
+const (
+	FollowUpMessage = "message"
+	FollowUpEdit    = "edit"
+)
+
+// Settings are a subscriber's notification preferences.
+type Settings struct {
+	ChatID int64
+	// Quiet is the quiet hours, e.g. "22:00-07:00", or empty for none
+	Quiet string
+	// Digest is DigestDaily, DigestWeekly or empty for none
+	Digest     string
+	LastDigest time.Time
+	// FollowUps is FollowUpMessage, FollowUpEdit or empty for none
+	FollowUps string
+}
+
+func Subscribers(db *sql.DB) []int64 {
+	rows, err := db.Query("SELECT ChatID FROM subscribers WHERE Unsubscribed = 0 ORDER BY ChatID;")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var chat_ids []int64
+	for rows.Next() {
+		var chat_id int64
+		if err := rows.Scan(&chat_id); err != nil {
+			log.Fatal(err)
+		}
+		chat_ids = append(chat_ids, chat_id)
+	}
+	return chat_ids
+}
+
+// Subscribe reports whether the chat was not already subscribed.
+func Subscribe(db *sql.DB, chat_id int64) bool {
+	q := `
+    INSERT INTO subscribers(ChatID) VALUES (?)
+    ON CONFLICT(ChatID) DO UPDATE SET Unsubscribed = 0 WHERE Unsubscribed = 1;`
+	res, err := db.Exec(q, chat_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rowsAffected(res) > 0
+}
+
+// SubscribeNew subscribes a chat unless it has been subscribed before,
+// so chats that unsubscribed stay that way.
+func SubscribeNew(db *sql.DB, chat_id int64) {
+	if _, err := db.Exec("INSERT OR IGNORE INTO subscribers(ChatID) VALUES (?);", chat_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Unsubscribe stops notifications to a chat, keeping its watches and
+// settings in case it subscribes again.
+func Unsubscribe(db *sql.DB, chat_id int64) bool {
+	q := "UPDATE subscribers SET Unsubscribed = 1 WHERE ChatID = ? AND Unsubscribed = 0;"
+	res, err := db.Exec(q, chat_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rowsAffected(res) > 0
+}
+
+func IsSubscribed(db *sql.DB, chat_id int64) bool {
+	var n int
+	q := "SELECT COUNT(*) FROM subscribers WHERE ChatID = ? AND Unsubscribed = 0;"
+	err := db.QueryRow(q, chat_id).Scan(&n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n > 0
+}
+
+// ClaimUnowned gives watches and notifications without a chat to chat_id.
+func ClaimUnowned(db *sql.DB, chat_id int64) {
+	for _, table := range []string{"watches", "notified"} {
+		if _, err := db.Exec("UPDATE "+table+" SET ChatID = ? WHERE ChatID = 0;", chat_id); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// SubscriberSettings returns a chat's settings, which are all unset for
+// chats that never subscribed.
+func SubscriberSettings(db *sql.DB, chat_id int64) Settings {
+	s := Settings{ChatID: chat_id}
+	var last_digest sql.NullTime
+	q := "SELECT Quiet, Digest, LastDigest, FollowUps FROM subscribers WHERE ChatID = ?;"
+	err := db.QueryRow(q, chat_id).Scan(&s.Quiet, &s.Digest, &last_digest, &s.FollowUps)
+	if err == sql.ErrNoRows {
+		return s
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	s.LastDigest = last_digest.Time
+	return s
+}
+
+func SetQuiet(db *sql.DB, chat_id int64, quiet string) {
+	if _, err := db.Exec("UPDATE subscribers SET Quiet = ? WHERE ChatID = ?;", quiet, chat_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// SetDigest changes a chat's digest period, counting the next digest from
+// now.
+func SetDigest(db *sql.DB, chat_id int64, digest string) {
+	q := "UPDATE subscribers SET Digest = ?, LastDigest = ? WHERE ChatID = ?;"
+	if _, err := db.Exec(q, digest, time.Now().UTC().Format(TimeFormat), chat_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func SetFollowUps(db *sql.DB, chat_id int64, mode string) {
+	if _, err := db.Exec("UPDATE subscribers SET FollowUps = ? WHERE ChatID = ?;", mode, chat_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DigestSent(db *sql.DB, chat_id int64, t time.Time) {
+	q := "UPDATE subscribers SET LastDigest = ? WHERE ChatID = ?;"
+	if _, err := db.Exec(q, t.UTC().Format(TimeFormat), chat_id); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func rowsAffected(res sql.Result) int64 {
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return n
+}
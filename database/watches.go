@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+)
+
+type WatchRow struct {
+	ID        int64
+	ChatID    int64
+	Rule      string
+	Timestamp string
+}
+
+func createWatchTable(db *sql.DB) {
+	sql_table := `
+    CREATE TABLE IF NOT EXISTS watches(
+        ID INTEGER PRIMARY KEY AUTOINCREMENT,
+        Rule TEXT NOT NULL,
+        Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+    );`
+	if _, err := db.Exec(sql_table); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Watches returns the watches of a chat.
+func Watches(db *sql.DB, chat_id int64) []WatchRow {
+	q := `
+    SELECT ID, ChatID, Rule, DATETIME(Timestamp, 'localtime')
+    FROM watches
+    WHERE ChatID = ?
+    ORDER BY ID;`
+	return queryWatches(db, q, chat_id)
+}
+
+func AllWatches(db *sql.DB) []WatchRow {
+	q := `
+    SELECT ID, ChatID, Rule, DATETIME(Timestamp, 'localtime')
+    FROM watches
+    ORDER BY ChatID, ID;`
+	return queryWatches(db, q)
+}
+
+func queryWatches(db *sql.DB, q string, parameters ...interface{}) []WatchRow {
+	rows, err := db.Query(q, parameters...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	var watches []WatchRow
+	for rows.Next() {
+		w := WatchRow{}
+		if err := rows.Scan(&w.ID, &w.ChatID, &w.Rule, &w.Timestamp); err != nil {
+			log.Fatal(err)
+		}
+		watches = append(watches, w)
+	}
+	return watches
+}
+
+func AddWatch(db *sql.DB, chat_id int64, rule string) int64 {
+	res, err := db.Exec("INSERT INTO watches(ChatID, Rule) VALUES (?, ?);", chat_id, rule)
+	if err != nil {
+		log.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+// RemoveWatch reports whether the chat had a watch with the id.
+func RemoveWatch(db *sql.DB, chat_id, id int64) bool {
+	res, err := db.Exec("DELETE FROM watches WHERE ID = ? AND ChatID = ?;", id, chat_id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rowsAffected(res) > 0
+}
@@ -0,0 +1,145 @@
+package fetch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const DefaultUserAgent = "gym-stock-bot (+https://github.com/maxtrussell/gym-stock-bot)"
+
+// Fetcher gets pages politely: requests to a host are limited to
+// HostLimit at a time and spaced HostDelay apart, and failed requests are
+// retried with exponential backoff.
+type Fetcher struct {
+	Client    *http.Client
+	UserAgent string
+	Retries   int
+	Backoff   time.Duration
+	HostLimit int
+	HostDelay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+type host struct {
+	slots chan struct{}
+	mu    sync.Mutex
+	last  time.Time
+}
+
+// StatusError is returned for non 2xx responses.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// temporary reports whether a retry could succeed
+func (e StatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func New(timeout time.Duration, retries int, host_limit int, host_delay time.Duration, user_agent string) *Fetcher {
+	if host_limit < 1 {
+		host_limit = 1
+	}
+	if user_agent == "" {
+		user_agent = DefaultUserAgent
+	}
+	return &Fetcher{
+		Client:    &http.Client{Timeout: timeout},
+		UserAgent: user_agent,
+		Retries:   retries,
+		Backoff:   time.Second,
+		HostLimit: host_limit,
+		HostDelay: host_delay,
+		hosts:     map[string]*host{},
+	}
+}
+
+// Get returns the body of a page, retrying network errors, 429s and 5xxs.
+func (f *Fetcher) Get(page_url string) ([]byte, error) {
+	u, err := url.Parse(page_url)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := f.Backoff
+	for attempt := 0; ; attempt++ {
+		body, err := f.get(u)
+		if err == nil {
+			return body, nil
+		}
+		if status_err, ok := err.(StatusError); ok && !status_err.temporary() {
+			return nil, err
+		}
+		if attempt >= f.Retries {
+			return nil, fmt.Errorf("giving up after %d attempts: %s", attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (f *Fetcher) Document(page_url string) (*goquery.Document, error) {
+	body, err := f.Get(page_url)
+	if err != nil {
+		return nil, err
+	}
+	return goquery.NewDocumentFromReader(bytes.NewReader(body))
+}
+
+func (f *Fetcher) get(u *url.URL) ([]byte, error) {
+	h := f.host(u.Host)
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	h.wait(f.HostDelay)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, StatusError{URL: u.String(), StatusCode: resp.StatusCode}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (f *Fetcher) host(name string) *host {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h, ok := f.hosts[name]
+	if !ok {
+		h = &host{slots: make(chan struct{}, f.HostLimit)}
+		f.hosts[name] = h
+	}
+	return h
+}
+
+// wait blocks until delay has passed since the last request to the host
+func (h *host) wait(delay time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if next := h.last.Add(delay); time.Now().Before(next) {
+		time.Sleep(time.Until(next))
+	}
+	h.last = time.Now()
+}
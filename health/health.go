@@ -0,0 +1,79 @@
+package health
+
+import (
+	"fmt"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+)
+
+// Kinds of anomaly, stored with each run so an operator is only alerted
+// when a product's kind changes.
+const (
+	ScrapeFailed = "scrape_failed"
+	NoItems      = "no_items"
+	EmptyNames   = "empty_names"
+	NoPrices     = "no_prices"
+	CountSwing   = "count_swing"
+)
+
+// A swing is a change in item count of more than SwingRatio of the
+// baseline, and at least MinSwing items.
+const (
+	SwingRatio = 0.5
+	MinSwing   = 3
+)
+
+type Anomaly struct {
+	Kind  string
+	Cause string
+}
+
+// Check looks for signs that a product page changed structure under its
+// parser. baseline is the product's last healthy run, or nil if it has
+// never had one.
+func Check(items []item.Item, err error, baseline *database.RunProduct) *Anomaly {
+	if err != nil {
+		return &Anomaly{Kind: ScrapeFailed, Cause: fmt.Sprintf("scrape failed: %s", err)}
+	}
+	if len(items) == 0 {
+		if baseline != nil && baseline.Items > 0 {
+			return &Anomaly{
+				Kind:  NoItems,
+				Cause: fmt.Sprintf("no items found, last healthy run had %d", baseline.Items),
+			}
+		}
+		return nil
+	}
+
+	empty_names := 0
+	no_prices := 0
+	for _, i := range items {
+		if i.Name == "" {
+			empty_names++
+		}
+		if !i.Price.Valid() {
+			no_prices++
+		}
+	}
+	if empty_names == len(items) {
+		return &Anomaly{Kind: EmptyNames, Cause: fmt.Sprintf("all %d item names are empty", len(items))}
+	}
+	if no_prices == len(items) {
+		return &Anomaly{Kind: NoPrices, Cause: fmt.Sprintf("no price could be parsed for any of %d items", len(items))}
+	}
+
+	if baseline != nil && baseline.Items > 0 {
+		diff := len(items) - baseline.Items
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff >= MinSwing && float64(diff) > SwingRatio*float64(baseline.Items) {
+			return &Anomaly{
+				Kind:  CountSwing,
+				Cause: fmt.Sprintf("item count went from %d to %d", baseline.Items, len(items)),
+			}
+		}
+	}
+	return nil
+}
@@ -1,47 +1,149 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
-	"regexp"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 
 	"github.com/maxtrussell/gym-stock-bot/analytics"
 	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/fetch"
+	"github.com/maxtrussell/gym-stock-bot/health"
 	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
 	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/notify"
+	"github.com/maxtrussell/gym-stock-bot/quiet"
+	"github.com/maxtrussell/gym-stock-bot/scheduler"
 	"github.com/maxtrussell/gym-stock-bot/telegram"
-	"github.com/maxtrussell/gym-stock-bot/vendors/rep"
-	"github.com/maxtrussell/gym-stock-bot/vendors/rogue"
+	"github.com/maxtrussell/gym-stock-bot/vendors"
+	_ "github.com/maxtrussell/gym-stock-bot/vendors/rep"
+	_ "github.com/maxtrussell/gym-stock-bot/vendors/rogue"
+	_ "github.com/maxtrussell/gym-stock-bot/vendors/titan"
+	"github.com/maxtrussell/gym-stock-bot/watch"
 	"github.com/maxtrussell/gym-stock-bot/web"
 )
 
+type options struct {
+	telegram_api string
+	update_db    bool
+	test         bool
+	fetcher      *fetch.Fetcher
+	// notifiers get every alert, on top of each chat's telegram messages
+	notifiers []notify.Notifier
+	// notifier_chat owns watches alerted on by the notifiers alone, for
+	// people not using telegram. 0 is the watches imported without -chat.
+	notifier_chat int64
+	// operator_chat gets scraper health alerts, if set
+	operator_chat int64
+	// allowed_chats may use the telegram bot's commands
+	allowed_chats map[int64]bool
+	// timeout and retries are for notifier requests
+	timeout time.Duration
+	retries int
+	// cooldown is the least time between alerts to a chat about an item
+	cooldown time.Duration
+	// confirmations is how many runs in a row a stock change must be seen
+	// in before it is trusted
+	confirmations int
+}
+
 func main() {
 	telegram_api_ptr := flag.String("api", "", "api token for telegram bot")
-	telegram_chat_id_ptr := flag.String("chat", "", "chat id for telegram bot")
+	telegram_chat_id_ptr := flag.String("chat", "", "default subscriber chat id, which also owns watches from before subscribers")
 	telegram_server := flag.Bool("server", false, "whether or not to be a telegram server")
 	test_ptr := flag.Bool("test", false, "whether to run offline for test purposes")
 	update_test_files_ptr := flag.Bool("update-test-files", false, "downloads all test files")
-	update_db_ptr := flag.Bool("update-db", false, "whether to update the stock db")
+	update_db_ptr := flag.Bool("update-db", false, "whether to update the stock db, needed for price alerts")
 	analytics_ptr := flag.String("analyze", "", "item id name to analyze")
+	products_ptr := flag.String("products", "products.json", "product catalog, defaults to the built-in list if missing")
+	daemon_ptr := flag.Bool("daemon", false, "keep running, scraping on an interval and serving telegram and web")
+	interval_ptr := flag.Duration("interval", 10*time.Minute, "default scrape interval in daemon mode")
+	jitter_ptr := flag.Duration("jitter", time.Minute, "random delay added to each scrape interval in daemon mode")
+	vendor_intervals_ptr := flag.String("vendor-intervals", "", "per vendor scrape intervals, e.g. Rogue=5m,RepFitness=15m")
+	import_state_ptr := flag.Bool("import-state", false, "import watched.txt, notified_items.txt and last_in_stock.txt into the db")
+	timeout_ptr := flag.Duration("timeout", 30*time.Second, "timeout for each page request")
+	retries_ptr := flag.Int("retries", 3, "times to retry a failed page request")
+	host_limit_ptr := flag.Int("host-limit", 2, "max concurrent requests to a host")
+	host_delay_ptr := flag.Duration("host-delay", time.Second, "min delay between requests to a host")
+	user_agent_ptr := flag.String("user-agent", fetch.DefaultUserAgent, "user agent for page requests")
+	check_rules_ptr := flag.Bool("check-rules", false, "print the current items matched by each watch rule")
+	webhooks_ptr := flag.String("webhook", "", "comma separated urls to POST alerts to as json")
+	webhook_secret_ptr := flag.String("webhook-secret", "", "secret to sign webhook payloads with")
+	discord_ptr := flag.String("discord-webhook", "", "comma separated discord webhook urls to post alerts to")
+	slack_ptr := flag.String("slack-webhook", "", "comma separated slack webhook urls to post alerts to")
+	ntfy_ptr := flag.String("ntfy", "", "ntfy topic url to push alerts to, e.g. https://ntfy.sh/my-topic")
+	ntfy_token_ptr := flag.String("ntfy-token", "", "access token for the ntfy server")
+	gotify_ptr := flag.String("gotify", "", "gotify server url to push alerts to")
+	gotify_token_ptr := flag.String("gotify-token", "", "gotify application token")
+	smtp_host_ptr := flag.String("smtp-host", "", "smtp server to email alerts through")
+	smtp_port_ptr := flag.Int("smtp-port", 587, "smtp server port")
+	smtp_user_ptr := flag.String("smtp-user", "", "smtp username, if the server needs auth")
+	smtp_password_ptr := flag.String("smtp-password", "", "smtp password")
+	smtp_starttls_ptr := flag.Bool("smtp-starttls", true, "upgrade smtp connections with STARTTLS")
+	smtp_from_ptr := flag.String("smtp-from", "", "sender of alert emails")
+	smtp_to_ptr := flag.String("smtp-to", "", "comma separated recipients of alert emails")
+	operator_chat_ptr := flag.String("operator-chat", "", "chat id for scraper health alerts, defaults to -chat")
+	notifier_chat_ptr := flag.Int64("notifier-chat", 0, "chat id whose watches the non-telegram notifiers also alert on, 0 for watches imported without -chat")
+	allowed_chats_ptr := flag.String("allowed-chats", "", "comma separated chat ids allowed to use bot commands, besides -chat and -operator-chat")
+	cooldown_ptr := flag.Duration("cooldown", 0, "least time between alerts to a chat about the same item")
+	confirmations_ptr := flag.Int("confirmations", 1, "runs in a row a stock change must be seen in before alerting")
 	flag.Parse()
 
 	start_time := time.Now()
 	fmt.Printf("Current time: %s\n", start_time)
 
-	if *telegram_server {
-		go telegram.ListenAndServe(*telegram_api_ptr)
-		web.ListenAndServe()
+	var default_chat_id int64
+	if *telegram_chat_id_ptr != "" {
+		var err error
+		default_chat_id, err = strconv.ParseInt(*telegram_chat_id_ptr, 10, 64)
+		if err != nil {
+			log.Fatalf("bad chat id %q: %s", *telegram_chat_id_ptr, err)
+		}
+		db := database.Setup()
+		database.SubscribeNew(db, default_chat_id)
+		database.ClaimUnowned(db, default_chat_id)
+		db.Close()
+	}
+	operator_chat := default_chat_id
+	if *operator_chat_ptr != "" {
+		var err error
+		operator_chat, err = strconv.ParseInt(*operator_chat_ptr, 10, 64)
+		if err != nil {
+			log.Fatalf("bad operator chat id %q: %s", *operator_chat_ptr, err)
+		}
+	}
+	allowed_chats := parse_allowed_chats(*allowed_chats_ptr, default_chat_id, operator_chat)
+
+	if *import_state_ptr {
+		db := database.Setup()
+		import_state(db, default_chat_id)
+		db.Close()
 		return
 	}
+	if _, err := os.Stat("watched.txt"); err == nil {
+		// watched.txt is no longer read, so carry it over on upgrade
+		db := database.Setup()
+		if len(database.AllWatches(db)) > 0 {
+			fmt.Println("Warning: watched.txt is no longer read and the db already has watches, so it was not imported. Add any missing rules with /watch and remove it.")
+		} else {
+			fmt.Println("Importing watched.txt into the db")
+			import_state(db, default_chat_id)
+		}
+		db.Close()
+	}
 
 	if *analytics_ptr != "" {
 		db := database.Setup()
@@ -49,30 +151,317 @@ func main() {
 		return
 	}
 
-	all_products := product.Products
+	all_products, err := product.Load(*products_ptr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := vendors.ValidateAll(all_products); err != nil {
+		log.Fatal(err)
+	}
+
+	if *telegram_server {
+		db := database.Setup()
+		go telegram.ListenAndServe(context.Background(), *telegram_api_ptr, db, allowed_chats)
+		web.ListenAndServe(context.Background(), db, all_products)
+		return
+	}
+
+	fetcher := fetch.New(*timeout_ptr, *retries_ptr, *host_limit_ptr, *host_delay_ptr, *user_agent_ptr)
 	if *update_test_files_ptr {
-		get_test_files(all_products)
+		get_test_files(fetcher, all_products)
 	}
 
-	ch := make(chan []item.Item)
-	var items []item.Item
-	for _, product := range all_products {
+	opts := options{
+		telegram_api:  *telegram_api_ptr,
+		update_db:     *update_db_ptr,
+		test:          *test_ptr,
+		fetcher:       fetcher,
+		operator_chat: operator_chat,
+		notifier_chat: *notifier_chat_ptr,
+		allowed_chats: allowed_chats,
+		timeout:       *timeout_ptr,
+		retries:       *retries_ptr,
+		cooldown:      *cooldown_ptr,
+		confirmations: *confirmations_ptr,
+	}
+	if opts.confirmations < 1 {
+		log.Fatal("-confirmations must be at least 1")
+	}
+	for _, u := range split_list(*webhooks_ptr) {
+		webhook := notify.NewWebhook(u, *webhook_secret_ptr, *timeout_ptr, *retries_ptr)
+		opts.notifiers = append(opts.notifiers, webhook)
+	}
+	for _, u := range split_list(*discord_ptr) {
+		opts.notifiers = append(opts.notifiers, notify.NewDiscord(u, *timeout_ptr, *retries_ptr))
+	}
+	for _, u := range split_list(*slack_ptr) {
+		opts.notifiers = append(opts.notifiers, notify.NewSlack(u, *timeout_ptr, *retries_ptr))
+	}
+	if *ntfy_ptr != "" {
+		ntfy, err := notify.NewNtfy(*ntfy_ptr, *ntfy_token_ptr, *timeout_ptr, *retries_ptr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.notifiers = append(opts.notifiers, ntfy)
+	}
+	if *gotify_ptr != "" {
+		if *gotify_token_ptr == "" {
+			log.Fatal("-gotify needs -gotify-token")
+		}
+		opts.notifiers = append(opts.notifiers, notify.NewGotify(*gotify_ptr, *gotify_token_ptr, *timeout_ptr, *retries_ptr))
+	}
+	if *smtp_host_ptr != "" {
+		email := notify.Email{
+			Host:     *smtp_host_ptr,
+			Port:     *smtp_port_ptr,
+			Username: *smtp_user_ptr,
+			Password: *smtp_password_ptr,
+			StartTLS: *smtp_starttls_ptr,
+			From:     *smtp_from_ptr,
+			To:       split_list(*smtp_to_ptr),
+		}
+		if email.From == "" || len(email.To) == 0 {
+			log.Fatal("-smtp-host needs -smtp-from and -smtp-to")
+		}
+		opts.notifiers = append(opts.notifiers, email)
+	}
+
+	if *daemon_ptr {
+		vendor_intervals, err := scheduler.ParseVendorIntervals(*vendor_intervals_ptr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for brand := range vendor_intervals {
+			if _, err := vendors.Get(brand); err != nil {
+				log.Fatal(err)
+			}
+		}
+		sched := scheduler.New(*interval_ptr, *jitter_ptr, vendor_intervals)
+		run_daemon(all_products, sched, opts)
+		return
+	}
+
+	results := scrape(all_products, opts)
+	items := all_items(results)
+	if *check_rules_ptr {
+		check_rules(items, opts)
+		return
+	}
+	process_items(items, items, opts)
+	report_run(results, start_time, opts)
+
+	end_time := time.Now()
+	fmt.Println()
+	fmt.Printf("Completed in %.2f seconds\n", end_time.Sub(start_time).Seconds())
+}
+
+// run_daemon scrapes products as they come due until SIGINT or SIGTERM,
+// running the telegram and web servers alongside.
+func run_daemon(all_products []product.Product, sched *scheduler.Scheduler, opts options) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Printf("Received %s, shutting down...\n", sig)
+		cancel()
+	}()
+
+	db := database.Setup()
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	if opts.telegram_api != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			telegram.ListenAndServe(ctx, opts.telegram_api, db, opts.allowed_chats)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		web.ListenAndServe(ctx, db, all_products)
+	}()
+
+	// Latest items for every product, as products are scraped at
+	// different times
+	latest := map[string][]item.Item{}
+	for {
+		now := time.Now()
+		due := sched.Due(all_products, now)
+		if len(due) > 0 {
+			fmt.Printf("Current time: %s\n", now)
+			for _, p := range due {
+				sched.Scraped(p, now)
+				latest[p.Name] = nil
+			}
+			results := scrape(due, opts)
+			scraped := all_items(results)
+			for _, i := range scraped {
+				latest[i.Product.Name] = append(latest[i.Product.Name], i)
+			}
+			var items []item.Item
+			for _, p := range all_products {
+				items = append(items, latest[p.Name]...)
+			}
+			process_items(items, scraped, opts)
+			report_run(results, now, opts)
+			fmt.Println()
+			fmt.Printf("Completed in %.2f seconds\n", time.Since(now).Seconds())
+		}
+
+		wait := time.Until(sched.Next())
+		if sched.Next().IsZero() {
+			// No products to scrape
+			wait = sched.Interval
+		}
+		fmt.Printf("Next scrape in %s\n", wait.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+type scrape_result struct {
+	product  product.Product
+	items    []item.Item
+	err      error
+	duration time.Duration
+}
+
+// scrape gets every product concurrently, returning results in the order
+// of products.
+func scrape(products []product.Product, opts options) []scrape_result {
+	ch := make(chan scrape_result)
+	for _, product := range products {
 		fmt.Printf("Getting %s...\n", product.Name)
-		go make_items(ch, product, *test_ptr)
+		go make_items(ch, product, opts)
 	}
 
-	for _, _ = range all_products {
-		items = append(items, <-ch...)
+	by_name := map[string]scrape_result{}
+	for _, _ = range products {
+		result := <-ch
+		by_name[result.product.Name] = result
+	}
+	var results []scrape_result
+	for _, p := range products {
+		results = append(results, by_name[p.Name])
+	}
+	return results
+}
+
+func all_items(results []scrape_result) []item.Item {
+	var items []item.Item
+	for _, r := range results {
+		items = append(items, r.items...)
+	}
+	return items
+}
+
+// report_run prints a summary of a scrape and stores it in the runs table,
+// alerting the operator to products whose health changed.
+func report_run(results []scrape_result, started time.Time, opts options) {
+	db := database.Setup()
+	defer db.Close()
+
+	run := database.Run{
+		Duration:  time.Since(started).Seconds(),
+		Attempted: len(results),
+	}
+	var alerts []string
+	fmt.Println()
+	fmt.Println("Products:")
+	for _, r := range results {
+		run_product := database.RunProduct{
+			ProductName: r.product.Name,
+			Brand:       r.product.Brand,
+			Category:    r.product.Category,
+			Items:       len(r.items),
+			Duration:    r.duration.Seconds(),
+		}
+		if r.err != nil {
+			run.Failed++
+			run_product.Error = r.err.Error()
+			fmt.Printf("- %s: FAILED in %.2fs: %s\n", r.product.Name, r.duration.Seconds(), r.err)
+		} else {
+			run.Succeeded++
+			fmt.Printf("- %s: %d items in %.2fs\n", r.product.Name, len(r.items), r.duration.Seconds())
+		}
+		if alert := check_health(db, r, &run_product); alert != "" {
+			alerts = append(alerts, alert)
+		}
+		run.Items += len(r.items)
+		run.Products = append(run.Products, run_product)
+	}
+	fmt.Printf(
+		"Attempted %d products, %d succeeded, %d failed, %d items\n",
+		run.Attempted,
+		run.Succeeded,
+		run.Failed,
+		run.Items,
+	)
+
+	database.SaveRun(db, started, run)
+	if len(alerts) > 0 {
+		msg := "Scraper health:\n\n" + strings.Join(alerts, "\n\n")
+		if opts.operator_chat != 0 {
+			send_notification(db, opts, opts.operator_chat, msg)
+		} else {
+			fmt.Println()
+			fmt.Println(msg)
+		}
 	}
+}
 
-	watched_terms := read_watched()
+// check_health records any anomaly in a product's scrape, returning an
+// alert if it differs from the product's previous run.
+func check_health(db *sql.DB, r scrape_result, run_product *database.RunProduct) string {
+	var baseline *database.RunProduct
+	if healthy, ok := database.LastHealthyRunProduct(db, r.product.Name); ok {
+		baseline = &healthy
+	}
+	previous, _ := database.LastRunProduct(db, r.product.Name)
+	if previous.Anomaly == health.CountSwing && previous.Items == len(r.items) {
+		// The new count held for two runs, so take it as the new normal
+		baseline = &previous
+	}
+	parser := fmt.Sprintf("%s %s parser", r.product.Brand, r.product.Category)
+
+	anomaly := health.Check(r.items, r.err, baseline)
+	if anomaly == nil {
+		if previous.Anomaly != "" {
+			return fmt.Sprintf("Recovered: %s (%s)\n%s", r.product.Name, parser, r.product.URL)
+		}
+		return ""
+	}
+	run_product.Anomaly = anomaly.Kind
+	if anomaly.Kind == previous.Anomaly {
+		return ""
+	}
+	return fmt.Sprintf(
+		"Anomaly: %s (%s)\nSuspected cause: %s\n%s",
+		r.product.Name,
+		parser,
+		anomaly.Cause,
+		r.product.URL,
+	)
+}
+
+// process_items reports available items, sends each subscriber the
+// items matching their watches and records stock for a set of items.
+// Only the scraped items, a subset of them, count towards confirming
+// stock changes.
+func process_items(items []item.Item, scraped []item.Item, opts options) {
+	db := database.Setup()
+	defer db.Close()
 
 	fmt.Println("")
 	fmt.Println("Available Products:")
-	already_notified := get_notified_items()
-	var notify_items []item.Item
 	var available_items []item.Item
-	var watched_available_items []item.Item
 	curr_product := &product.Product{}
 	for _, i := range items {
 		if i.IsAvailable() {
@@ -86,126 +475,417 @@ func main() {
 				fmt.Printf("Link: %s\n", curr_product.URL)
 			}
 			fmt.Printf("- %s\n", i)
-			if watched(i, watched_terms) {
-				watched_available_items = append(watched_available_items, i)
-				if !already_notified[i.ID()] {
-					notify_items = append(notify_items, i)
+		}
+	}
+
+	// Update the stock db, keeping the previously recorded prices for
+	// price triggers
+	var previous map[string]money.Money
+	if opts.update_db {
+		previous = database.LatestPrices(db)
+		database.UpdateStock(db, items)
+	}
+
+	// Products by name, for rebuilding items from the stock table
+	products := map[string]*product.Product{}
+	for _, i := range items {
+		products[i.Product.Name] = i.Product
+	}
+	now := time.Now()
+
+	// Only trust stock changes seen in enough consecutive runs, so items
+	// flapping between scrapes do not re-notify
+	observed := map[string]bool{}
+	for _, i := range scraped {
+		observed[i.ID()] = i.IsAvailable()
+	}
+	confirmed := database.Observe(db, observed, opts.confirmations)
+	in_stock := map[string]bool{}
+	for _, i := range items {
+		in_stock[i.ID()] = i.IsAvailable()
+	}
+
+	notified := map[int64][]string{}
+	var alerts []notify.Alert
+	chat_alerts := map[int64]notify.Alert{}
+	sent := map[int64]bool{}
+	queued := map[int64]bool{}
+	chats, subscribed := alert_chats(db, opts)
+	for _, chat_id := range chats {
+		rules, err := watch.LoadStored(db, chat_id)
+		if err != nil {
+			log.Printf("Skipping chat %d: %s\n", chat_id, err)
+			continue
+		}
+		already_notified := database.NotifiedItems(db, chat_id)
+		last_notified := database.LastNotified(db, chat_id, database.NotifiedInStock)
+		muted := database.MutedItems(db, chat_id, now)
+		notified[chat_id] = []string{}
+		for id := range already_notified {
+			// Items not scraped this run stay notified unless known to
+			// be out of stock
+			if _, ok := in_stock[id]; !ok {
+				if available, known := confirmed[id]; available || !known {
+					notified[chat_id] = append(notified[chat_id], id)
 				}
 			}
 		}
-	}
 
-	// Update last_in_stock.txt
-	last_in_stock(available_items)
+		var notify_items []item.Item
+		// Items the chat was told of that sold out again
+		var sold_out []item.Item
+		for _, i := range items {
+			if !rules.Watched(i) {
+				continue
+			}
+			available, known := confirmed[i.ID()]
+			if !available {
+				if already_notified[i.ID()] && known {
+					sold_out = append(sold_out, i)
+				} else if already_notified[i.ID()] {
+					// Not yet confirmed either way
+					notified[chat_id] = append(notified[chat_id], i.ID())
+				}
+				continue
+			}
+			if already_notified[i.ID()] {
+				notified[chat_id] = append(notified[chat_id], i.ID())
+				continue
+			}
+			if !i.IsAvailable() {
+				continue
+			}
+			if in_cooldown(last_notified, i.ID(), now, opts) {
+				fmt.Printf("Not notifying %d of %s again during cooldown\n", chat_id, i.ID())
+				continue
+			}
+			if muted[i.ID()] {
+				fmt.Printf("Not notifying %d of muted %s\n", chat_id, i.ID())
+				continue
+			}
+			notified[chat_id] = append(notified[chat_id], i.ID())
+			notify_items = append(notify_items, i)
+		}
 
-	// Send telegram notification
-	if *telegram_api_ptr != "" && *telegram_chat_id_ptr != "" {
-		msg := "Watched In Stock Items:\n"
-		curr_product = &product.Product{}
-		for _, i := range notify_items {
-			if i.Product.Name != curr_product.Name {
-				if curr_product.Name != "" {
-					msg += "\n"
+		var price_changes []watch.PriceChange
+		if previous != nil {
+			last_price := database.LastNotified(db, chat_id, database.NotifiedPrice)
+			for _, c := range rules.PriceChanges(items, previous) {
+				if !in_cooldown(last_price, c.Item.ID(), now, opts) && !muted[c.Item.ID()] {
+					price_changes = append(price_changes, c)
 				}
-				curr_product = i.Product
-				msg += fmt.Sprintf("%s:\n", curr_product.Name)
-				msg += fmt.Sprintf("Link: %s\n", curr_product.URL)
 			}
-			msg += fmt.Sprintf("> %s\n", i)
 		}
-		if len(notify_items) > 0 {
-			fmt.Println()
-			fmt.Println("Sending notification...")
-			fmt.Println(msg)
-			telegram.SendMessage(
-				*telegram_api_ptr,
-				*telegram_chat_id_ptr,
-				msg,
-			)
-		}
-		// Store notified items, so as to not re-notify
-		var item_names []string
-		for _, i := range watched_available_items {
-			item_names = append(item_names, i.ID())
-		}
-		err := ioutil.WriteFile("notified_items.txt", []byte(strings.Join(item_names, "\n")), 0644)
-		if err != nil {
-			log.Fatal(err)
+
+		alert := notify.NewAlert(rules, notify_items, price_changes)
+		alerts = append(alerts, alert)
+		chat_alerts[chat_id] = alert
+		if opts.telegram_api != "" && subscribed[chat_id] {
+			var pending []item.Item
+			sent[chat_id], queued[chat_id], pending = notify_chat(db, opts, chat_id, rules, alert, sold_out, products, now)
+			// Keep items whose follow-up failed notified, to retry it
+			notified[chat_id] = append(notified[chat_id], item_ids(pending)...)
 		}
 	}
 
-	// Update the stock db
-	if *update_db_ptr {
-		db := database.Setup()
-		database.UpdateStock(db, items)
+	// Other notifiers get everything any chat was notified of
+	merged := notify.Merge(alerts...)
+	merged_delivered := false
+	for _, n := range opts.notifiers {
+		if err := notify.Deliver(db, n, merged); err != nil {
+			log.Printf("Notifying %s %s: %s\n", n.Name(), n.Target(), err)
+		} else {
+			merged_delivered = true
+		}
 	}
 
-	end_time := time.Now()
-	fmt.Println()
-	fmt.Printf("Completed in %.2f seconds\n", end_time.Sub(start_time).Seconds())
-}
+	for chat_id, alert := range chat_alerts {
+		if merged_delivered && !sent[chat_id] {
+			log_notifications(db, chat_id, alert, now)
+		}
+		if opts.telegram_api == "" && len(opts.notifiers) == 0 {
+			// Nothing was sent, so leave the chat's state for real runs
+			delete(notified, chat_id)
+		} else if !sent[chat_id] && !queued[chat_id] && !merged_delivered {
+			// Leave out the new items, so they are alerted next run
+			notified[chat_id] = without(notified[chat_id], alert.Available)
+		}
+	}
 
-func get_notified_items() map[string]bool {
-	file, err := os.Open("notified_items.txt")
-	if err != nil {
-		// The file probably does not exist
-		return map[string]bool{}
+	// Store notified items, so as to not re-notify, and when items were
+	// last in stock
+	var available_ids []string
+	for _, i := range available_items {
+		available_ids = append(available_ids, i.ID())
 	}
-	defer file.Close()
+	database.SaveRunState(db, notified, available_ids, now)
+}
 
-	notified_items := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		notified_items[strings.Trim(scanner.Text(), "\n")] = true
+// alert_chats returns the chats whose watches are alerted on: the
+// subscribers, which get telegram messages, and the notifier chat if
+// there are other notifiers.
+func alert_chats(db *sql.DB, opts options) ([]int64, map[int64]bool) {
+	chats := database.Subscribers(db)
+	subscribed := map[int64]bool{}
+	for _, chat_id := range chats {
+		subscribed[chat_id] = true
+	}
+	if len(opts.notifiers) > 0 && !subscribed[opts.notifier_chat] {
+		chats = append(chats, opts.notifier_chat)
 	}
-	return notified_items
+	return chats, subscribed
 }
 
-func watched(i item.Item, watched_terms []string) bool {
-	for _, term := range watched_terms {
-		re := regexp.MustCompile(term)
-		if re.FindStringIndex(i.ID()) != nil {
-			return true
+// without returns ids less those of items
+func without(ids []string, items []item.Item) []string {
+	drop := map[string]bool{}
+	for _, id := range item_ids(items) {
+		drop[id] = true
+	}
+	kept := []string{}
+	for _, id := range ids {
+		if !drop[id] {
+			kept = append(kept, id)
 		}
 	}
-	return false
+	return kept
 }
 
-func make_items(ch chan []item.Item, product product.Product, test bool) {
-	var doc *goquery.Document
-	var err error
-	if test {
-		doc = get_test_doc(product)
+// in_cooldown reports whether a chat was notified of an item too recently
+// to be notified again
+func in_cooldown(last_notified map[string]time.Time, id string, now time.Time, opts options) bool {
+	t, ok := last_notified[id]
+	return ok && now.Sub(t) < opts.cooldown
+}
+
+// log_notifications records a sent alert, for cooldowns
+func log_notifications(db *sql.DB, chat_id int64, alert notify.Alert, now time.Time) {
+	var price_ids []string
+	for _, c := range alert.PriceChanges {
+		price_ids = append(price_ids, c.Item.ID())
+	}
+	database.LogNotifications(db, chat_id, database.NotifiedInStock, item_ids(alert.Available), now)
+	database.LogNotifications(db, chat_id, database.NotifiedPrice, price_ids, now)
+}
+
+func item_ids(items []item.Item) []string {
+	var ids []string
+	for _, i := range items {
+		ids = append(ids, i.ID())
+	}
+	return ids
+}
+
+// notify_chat sends a chat its alert and follow-ups on sold out items, or
+// queues them during the chat's quiet hours. Outside them it first sends
+// anything queued, and any digest that is due. Edits striking sold out
+// items are silent, so are made even in quiet hours. It reports whether
+// the alert was sent, or queued, and the sold out items whose follow-up
+// could not be sent. Sent notifications are logged.
+func notify_chat(
+	db *sql.DB,
+	opts options,
+	chat_id int64,
+	rules watch.Rules,
+	alert notify.Alert,
+	sold_out []item.Item,
+	products map[string]*product.Product,
+	now time.Time,
+) (sent bool, queued bool, pending []item.Item) {
+	n := notify.NewTelegram(opts.telegram_api, chat_id, opts.timeout, opts.retries)
+	settings := database.SubscriberSettings(db, chat_id)
+	hours, err := quiet.Parse(settings.Quiet)
+	if err != nil {
+		log.Printf("Chat %d: %s\n", chat_id, err)
+	}
+
+	follow_up := ""
+	if len(sold_out) > 0 && settings.FollowUps != "" {
+		if settings.FollowUps == database.FollowUpEdit {
+			rest, err := n.Strike(db, sold_out, now)
+			if err != nil {
+				log.Printf("Editing messages in chat %d: %s\n", chat_id, err)
+			}
+			struck := without(item_ids(sold_out), rest)
+			database.LogNotifications(db, chat_id, database.NotifiedSoldOut, struck, now)
+			sold_out = rest
+		}
+		if len(sold_out) > 0 {
+			follow_up = n.FollowUpText(db, sold_out, now)
+		}
+	}
+	if hours.Contains(now) {
+		msgs := n.Messages(alert)
+		if follow_up != "" {
+			msgs = append(msgs, follow_up)
+		}
+		for _, msg := range msgs {
+			fmt.Printf("Queueing notification to %d during quiet hours\n", chat_id)
+			database.QueueAlert(db, chat_id, msg)
+		}
+		if follow_up != "" {
+			n.MarkSoldOut(db, sold_out)
+		}
+		return false, true, nil
+	}
+
+	for _, msg := range notify.QueuedMessages(database.QueuedAlerts(db, chat_id)) {
+		if _, err := n.DeliverText(db, msg.Text); err != nil {
+			log.Printf("Notifying chat %d: %s\n", chat_id, err)
+			break
+		}
+		database.ClearQueuedAlerts(db, chat_id, msg.LastID)
+	}
+	if err := n.DeliverAlert(db, alert); err != nil {
+		log.Printf("Notifying chat %d: %s\n", chat_id, err)
 	} else {
-		doc, err = goquery.NewDocument(product.URL)
+		sent = true
+		log_notifications(db, chat_id, alert, now)
+	}
+	if follow_up != "" {
+		if _, err := n.DeliverText(db, follow_up); err != nil {
+			log.Printf("Notifying chat %d: %s\n", chat_id, err)
+			pending = sold_out
+		} else {
+			n.MarkSoldOut(db, sold_out)
+			database.LogNotifications(db, chat_id, database.NotifiedSoldOut, item_ids(sold_out), now)
+		}
+	}
+
+	period := map[string]time.Duration{
+		database.DigestDaily:  24 * time.Hour,
+		database.DigestWeekly: 7 * 24 * time.Hour,
+	}[settings.Digest]
+	if period == 0 || now.Sub(settings.LastDigest) < period {
+		return sent, false, pending
+	}
+	lookup := func(r database.StockRow) item.Item {
+		p, ok := products[r.ProductName]
+		if !ok {
+			p = &product.Product{Name: r.ProductName}
+		}
+		return r.Item(p)
+	}
+	changes := database.StockChanges(db, settings.LastDigest)
+	if msg := notify.DigestText(settings.Digest, changes, rules.Watched, lookup); msg != "" {
+		if _, err := n.DeliverText(db, msg); err != nil {
+			log.Printf("Notifying chat %d: %s\n", chat_id, err)
+			return sent, false, pending
+		}
+	}
+	database.DigestSent(db, chat_id, now)
+	return sent, false, pending
+}
+
+func check_rules(items []item.Item, opts options) {
+	db := database.Setup()
+	defer db.Close()
+	chats, _ := alert_chats(db, opts)
+	for _, chat_id := range chats {
+		rules, err := watch.LoadStored(db, chat_id)
 		if err != nil {
 			log.Fatal(err)
 		}
+		fmt.Println()
+		fmt.Printf("Chat %d:\n", chat_id)
+		for _, r := range rules {
+			fmt.Println()
+			fmt.Println(r)
+			matches := 0
+			for _, i := range items {
+				if r.Matches(i) {
+					matches++
+					if !r.Exclude && !rules.Watched(i) {
+						fmt.Printf("- %s: %s (excluded)\n", i.Product.Name, i)
+					} else {
+						fmt.Printf("- %s: %s\n", i.Product.Name, i)
+					}
+				}
+			}
+			if matches == 0 {
+				fmt.Println("- no matches")
+			}
+		}
 	}
-	var items []item.Item
-	switch product.Brand {
-	case "Rogue":
-		items = rogue.MakeRogue(doc, product)
-	case "RepFitness":
-		items = rep.MakeRep(doc, product)
+}
+
+// parse_allowed_chats parses the -allowed-chats flag, adding the given
+// chats. Unset chats (0) are skipped.
+func parse_allowed_chats(s string, chats ...int64) map[int64]bool {
+	allowed := map[int64]bool{}
+	for _, c := range split_list(s) {
+		chat_id, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			log.Fatalf("bad allowed chat id %q: %s", c, err)
+		}
+		allowed[chat_id] = true
+	}
+	for _, chat_id := range chats {
+		if chat_id != 0 {
+			allowed[chat_id] = true
+		}
+	}
+	return allowed
+}
+
+// split_list splits a comma separated flag, dropping empty entries
+func split_list(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func send_notification(db *sql.DB, opts options, chat_id int64, msg string) {
+	n := notify.NewTelegram(opts.telegram_api, chat_id, opts.timeout, opts.retries)
+	if _, err := n.DeliverText(db, msg); err != nil {
+		log.Println(err)
+	}
+}
+
+// make_items sends the product's items, or the reason it could not be
+// scraped. Parser panics are recovered so one broken page cannot stop the
+// run.
+func make_items(ch chan scrape_result, product product.Product, opts options) {
+	result := scrape_result{product: product}
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result.items = nil
+			result.err = fmt.Errorf("panic: %v", r)
+		}
+		result.duration = time.Since(start)
+		if result.err != nil {
+			fmt.Printf("Failed to get %s: %s\n", product.Name, result.err)
+		}
+		ch <- result
+	}()
+
+	var doc *goquery.Document
+	if opts.test {
+		doc, result.err = get_test_doc(product)
+	} else {
+		doc, result.err = opts.fetcher.Document(product.URL)
+	}
+	if result.err != nil {
+		return
 	}
-	ch <- items
+	result.items, result.err = vendors.MakeItems(doc, product)
 }
 
-func get_test_doc(p product.Product) *goquery.Document {
+func get_test_doc(p product.Product) (*goquery.Document, error) {
 	f, err := os.Open(p.GetTestFile())
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer f.Close()
-	doc, err := goquery.NewDocumentFromReader(f)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return doc
+	return goquery.NewDocumentFromReader(f)
 }
 
-func get_test_files(all_products []product.Product) {
+func get_test_files(fetcher *fetch.Fetcher, all_products []product.Product) {
 	// Make test_pages dir if needed
 	if _, err := os.Stat("test_pages"); os.IsNotExist(err) {
 		os.Mkdir("test_pages", 0755)
@@ -214,7 +894,7 @@ func get_test_files(all_products []product.Product) {
 	ch := make(chan bool)
 	for _, p := range all_products {
 		fmt.Printf("Getting test file for %s...\n", p.Name)
-		go get_test_file(p, ch)
+		go get_test_file(fetcher, p, ch)
 	}
 	for _, _ = range all_products {
 		<-ch
@@ -223,17 +903,13 @@ func get_test_files(all_products []product.Product) {
 	fmt.Println()
 }
 
-func get_test_file(product product.Product, ch chan bool) {
-	resp, err := http.Get(product.URL)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
+func get_test_file(fetcher *fetch.Fetcher, product product.Product, ch chan bool) {
+	body, err := fetcher.Get(product.URL)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Printf("Failed to get test file for %s: %s\n", product.Name, err)
+		ch <- false
+		return
 	}
-	defer resp.Body.Close()
 
 	err = ioutil.WriteFile(product.GetTestFile(), body, 0644)
 	if err != nil {
@@ -241,55 +917,3 @@ func get_test_file(product product.Product, ch chan bool) {
 	}
 	ch <- true
 }
-
-func last_in_stock(in_stock_now []item.Item) {
-	last_in_stock := read_last_in_stock()
-	t := time.Now()
-	for _, item := range in_stock_now {
-		last_in_stock[item.ID()] = t.Format("Jan 02, 2006 15:04")
-	}
-
-	contents := ""
-	for id, timestamp := range last_in_stock {
-		contents += fmt.Sprintf("%s :: %s\n", id, timestamp)
-	}
-	err := ioutil.WriteFile("last_in_stock.txt", []byte(contents), 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func read_last_in_stock() map[string]string {
-	last_in_stock := map[string]string{}
-	file, err := os.Open("last_in_stock.txt")
-	if os.IsNotExist(err) {
-		last_in_stock = map[string]string{}
-	} else if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line_parts := strings.Split(scanner.Text(), " :: ")
-		last_in_stock[line_parts[0]] = line_parts[1]
-	}
-	return last_in_stock
-}
-
-func read_watched() []string {
-	watched := []string{}
-	file, err := os.Open("watched.txt")
-	if os.IsNotExist(err) {
-		return watched
-	} else if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		watched = append(watched, scanner.Text())
-	}
-	return watched
-}
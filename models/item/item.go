@@ -3,20 +3,29 @@ package item
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strconv"
+	"strings"
 
+	"github.com/maxtrussell/gym-stock-bot/models/money"
 	"github.com/maxtrussell/gym-stock-bot/models/product"
 )
 
+var weight_re = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(lbs?|kg)\b`)
+
 var STOCK_EMOJIS = map[bool]string{
 	true:  "00002705",
 	false: "0000274C",
 }
 
 type Item struct {
-	Product      *product.Product
-	Name         string
-	Price        string
+	Product *product.Product
+	Name    string
+	Price   money.Money
+	// MaxPrice is set when the page only lists a price range
+	MaxPrice money.Money
+	// WasPrice is the regular price when the item is on sale
+	WasPrice     money.Money
 	Availability string
 }
 
@@ -34,19 +43,47 @@ func (i Item) String() string {
 	return fmt.Sprintf(
 		"%s @ %s, in stock: %s",
 		i.Name,
-		i.Price,
+		i.PriceString(),
 		get_emoji(STOCK_EMOJIS[i.IsAvailable()]),
 	)
 }
 
+func (i Item) PriceString() string {
+	s := i.Price.String()
+	if i.MaxPrice.Valid() {
+		s += " - " + i.MaxPrice.String()
+	}
+	if i.WasPrice.Valid() {
+		s += fmt.Sprintf(" (was %s)", i.WasPrice)
+	}
+	return s
+}
+
 func (i Item) ID() string {
 	return fmt.Sprintf("%s: %s", i.Product.Name, i.Name)
 }
 
+// Weight reads the weight in pounds from the item name, e.g. "45LB" or
+// "20KG Bumper Plate".
+func (i Item) Weight() (float64, bool) {
+	m := weight_re.FindStringSubmatch(i.Name)
+	if m == nil {
+		return 0, false
+	}
+	w, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	if strings.EqualFold(m[2], "kg") {
+		w *= 2.20462
+	}
+	return w, true
+}
+
 func get_emoji(s string) string {
 	r, err := strconv.ParseInt(s, 16, 32)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return string(r)
+	return string(rune(r))
 }
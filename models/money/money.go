@@ -0,0 +1,147 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrMissing = errors.New("missing price")
+
+// Money is an amount in the currency's minor unit. A zero Currency means
+// the price is unknown.
+type Money struct {
+	Cents    int64
+	Currency string
+}
+
+var symbols = []struct {
+	symbol   string
+	currency string
+}{
+	{"CA$", "CAD"},
+	{"US$", "USD"},
+	{"$", "USD"},
+	{"€", "EUR"},
+	{"£", "GBP"},
+}
+
+var display_symbols = map[string]string{
+	"USD": "$",
+	"CAD": "CA$",
+	"EUR": "€",
+	"GBP": "£",
+}
+
+var price_re = regexp.MustCompile(`(?:[A-Z]{2}\$|[$€£]|\b[A-Z]{3}\s)?\s*(?:\d[\d,]*(?:\.\d+)?|\.\d+)`)
+
+func USD(cents int64) Money {
+	return Money{Cents: cents, Currency: "USD"}
+}
+
+func (m Money) Valid() bool {
+	return m.Currency != ""
+}
+
+func (m Money) String() string {
+	if !m.Valid() {
+		return "N/A"
+	}
+	sign, cents := "", m.Cents
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	amount := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+	if symbol, ok := display_symbols[m.Currency]; ok {
+		return sign + symbol + amount
+	}
+	return sign + amount + " " + m.Currency
+}
+
+// Less reports whether m is cheaper than other. Prices in different
+// currencies are never comparable.
+func (m Money) Less(other Money) bool {
+	return m.Valid() && m.Currency == other.Currency && m.Cents < other.Cents
+}
+
+// Parse reads a single price like "$1,299.00", "275.0000", "$.99" or
+// "EUR 12.5". Prices without a currency marker are taken to be USD.
+// Negative prices are rejected.
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Money{}, ErrMissing
+	}
+
+	currency := ""
+	for _, sym := range symbols {
+		if strings.Contains(s, sym.symbol) {
+			currency = sym.currency
+			s = strings.Replace(s, sym.symbol, "", 1)
+			break
+		}
+	}
+	if currency == "" && len(s) > 3 && isCode(s[:3]) {
+		currency = s[:3]
+		s = s[3:]
+	}
+	if currency == "" {
+		currency = "USD"
+	}
+
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	parts := strings.SplitN(s, ".", 2)
+	if strings.Trim(parts[0], "0123456789") != "" || (parts[0] == "" && len(parts) == 1) {
+		return Money{}, fmt.Errorf("bad price %q", s)
+	}
+	var whole int64
+	if parts[0] != "" {
+		var err error
+		if whole, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			return Money{}, fmt.Errorf("bad price %q", s)
+		}
+	}
+	cents := whole * 100
+	if len(parts) == 2 {
+		frac := parts[1]
+		if frac == "" || strings.Trim(frac, "0123456789") != "" {
+			return Money{}, fmt.Errorf("bad price %q", s)
+		}
+		frac_cents, _ := strconv.ParseFloat("0."+frac, 64)
+		cents += int64(frac_cents*100 + 0.5)
+	}
+	return Money{Cents: cents, Currency: currency}, nil
+}
+
+// ParseRange reads text holding one or two prices, e.g. "$99 - $149" or
+// "From $99". high is unset unless the text is a range.
+func ParseRange(s string) (low, high Money, err error) {
+	matches := price_re.FindAllString(s, -1)
+	if len(matches) == 0 {
+		if strings.TrimSpace(s) == "" {
+			return Money{}, Money{}, ErrMissing
+		}
+		return Money{}, Money{}, fmt.Errorf("bad price %q", s)
+	}
+	low, err = Parse(matches[0])
+	if err != nil {
+		return Money{}, Money{}, err
+	}
+	if len(matches) > 1 {
+		if h, err := Parse(matches[1]); err == nil && low.Less(h) {
+			high = h
+		}
+	}
+	return low, high, nil
+}
+
+func isCode(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"$275", USD(27500)},
+		{"$1,299.00", USD(129900)},
+		{"  $12,345.5 ", USD(1234550)},
+		{"275.0000", USD(27500)},
+		{"US$19.99", USD(1999)},
+		{"CA$349.99", Money{34999, "CAD"}},
+		{"€12.50", Money{1250, "EUR"}},
+		{"£1,000", Money{100000, "GBP"}},
+		{"EUR 12.5", Money{1250, "EUR"}},
+		{"0.99", USD(99)},
+		{"$.99", USD(99)},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.in, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse("  "); err != ErrMissing {
+		t.Errorf("Parse of blank: %v, want ErrMissing", err)
+	}
+	for _, in := range []string{"Sold Out", "$", "$12.", "$1.2.3", "12.x", ".", "$-5", "-5", "+5", "$ -1.50"} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in        string
+		low, high Money
+	}{
+		{"$99", USD(9900), Money{}},
+		{"$.99", USD(99), Money{}},
+		{"Only .99", USD(99), Money{}},
+		{"$99 - $149", USD(9900), USD(14900)},
+		{"$1,099.00 – $1,349.00", USD(109900), USD(134900)},
+		{"From $99", USD(9900), Money{}},
+		{"Sale price $89.99", USD(8999), Money{}},
+		// A lower second price, like a sale price after the "was"
+		// price, is not a range. Was prices are parsed on their own.
+		{"$149.99 now $119.99", USD(14999), Money{}},
+		{"$119.99 $119.99", USD(11999), Money{}},
+		{"CA$99 - CA$149", Money{9900, "CAD"}, Money{14900, "CAD"}},
+		// Prices in different currencies are not a range
+		{"$99 / €90", USD(9900), Money{}},
+	}
+	for _, test := range tests {
+		low, high, err := ParseRange(test.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q): %s", test.in, err)
+			continue
+		}
+		if low != test.low || high != test.high {
+			t.Errorf("ParseRange(%q) = %+v, %+v, want %+v, %+v", test.in, low, high, test.low, test.high)
+		}
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	if _, _, err := ParseRange(""); err != ErrMissing {
+		t.Errorf("ParseRange of blank: %v, want ErrMissing", err)
+	}
+	if _, _, err := ParseRange("Sold Out"); err == nil || err == ErrMissing {
+		t.Errorf("ParseRange(\"Sold Out\"): %v, want a bad price error", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{USD(129900), "$1299.00"},
+		{USD(5), "$0.05"},
+		{USD(-150), "-$1.50"},
+		{Money{-150, "CHF"}, "-1.50 CHF"},
+		{Money{1250, "EUR"}, "€12.50"},
+		{Money{1250, "CHF"}, "12.50 CHF"},
+		{Money{}, "N/A"},
+	}
+	for _, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLess(t *testing.T) {
+	if !USD(100).Less(USD(200)) {
+		t.Error("$1 should be less than $2")
+	}
+	if USD(100).Less(Money{200, "EUR"}) {
+		t.Error("prices in different currencies should not compare")
+	}
+	if (Money{}).Less(USD(200)) {
+		t.Error("an unknown price should not be less")
+	}
+}
@@ -1,15 +1,81 @@
 package product
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
 	"strings"
+	"time"
 )
 
 type Product struct {
-	Name     string
-	URL      string
-	Brand    string
-	Category string
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Brand    string   `json:"brand"`
+	Category string   `json:"category"`
+	Tags     []string `json:"tags,omitempty"`
+	// Interval overrides how often the daemon scrapes this product, e.g. "30m"
+	Interval string `json:"interval,omitempty"`
+}
+
+// Load reads a JSON product catalog. If the file does not exist the
+// built-in Products are returned. Brands and categories are not checked
+// here, see vendors.ValidateAll.
+func Load(path string) ([]Product, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Products, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&products); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var msgs []string
+	seen := map[string]bool{}
+	for i, p := range products {
+		for _, msg := range p.check() {
+			msgs = append(msgs, fmt.Sprintf("product #%d (%q): %s", i+1, p.Name, msg))
+		}
+		if seen[p.Name] {
+			msgs = append(msgs, fmt.Sprintf("product #%d (%q): duplicate name", i+1, p.Name))
+		}
+		seen[p.Name] = true
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("%s:\n%s", path, strings.Join(msgs, "\n"))
+	}
+	return products, nil
+}
+
+func (p Product) check() []string {
+	var msgs []string
+	if p.Name == "" {
+		msgs = append(msgs, "missing name")
+	}
+	if p.Brand == "" {
+		msgs = append(msgs, "missing brand")
+	}
+	if p.Category == "" {
+		msgs = append(msgs, "missing category")
+	}
+	if u, err := url.Parse(p.URL); p.URL == "" || err != nil || u.Host == "" {
+		msgs = append(msgs, fmt.Sprintf("invalid url %q", p.URL))
+	}
+	if p.Interval != "" {
+		if d, err := time.ParseDuration(p.Interval); err != nil || d <= 0 {
+			msgs = append(msgs, fmt.Sprintf("invalid interval %q", p.Interval))
+		}
+	}
+	return msgs
 }
 
 func (p Product) GetTestFile() string {
@@ -123,7 +189,7 @@ var Products = []Product{
 	Product{
 		Name:     "Rep Fitness Color Bumper Plates",
 		Brand:    "RepFitness",
-		Category: "mutli",
+		Category: "multi",
 		URL:      "https://www.repfitness.com/bars-plates/olympic-plates/rep-color-bumper-plates",
 	},
 	Product{
@@ -156,4 +222,22 @@ var Products = []Product{
 		Category: "multi",
 		URL:      "https://www.repfitness.com/bars-plates/olympic-bars/rep-ez-curl-barbell",
 	},
+	Product{
+		Name:     "Titan T-3 Power Rack",
+		Brand:    "Titan",
+		Category: "rack",
+		URL:      "https://www.titan.fitness/products/t-3-series-power-rack",
+	},
+	Product{
+		Name:     "Titan Cast Iron Olympic Plates",
+		Brand:    "Titan",
+		Category: "multi",
+		URL:      "https://www.titan.fitness/products/cast-iron-olympic-plates",
+	},
+	Product{
+		Name:     "Titan Olympic Barbell 45LB",
+		Brand:    "Titan",
+		Category: "single",
+		URL:      "https://www.titan.fitness/products/olympic-barbell-45lb",
+	},
 }
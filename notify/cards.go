@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+// card is a product's part of an alert, for chat apps with rich
+// formatting.
+type card struct {
+	Section string
+	Product *product.Product
+	InStock bool
+	Items   []item.Item
+	Fields  []field
+}
+
+type field struct {
+	Name  string
+	Value string
+}
+
+// Card colors by stock state
+const (
+	inStockColor    = 0x1a7f37
+	outOfStockColor = 0xb42318
+)
+
+func (c card) color() int {
+	if c.InStock {
+		return inStockColor
+	}
+	return outOfStockColor
+}
+
+// cards returns a card per product and section of the alert.
+func cards(a Alert) []card {
+	var cs []card
+	add := func(section string, i item.Item, value string) {
+		if len(cs) == 0 || cs[len(cs)-1].Section != section || cs[len(cs)-1].Product.Name != i.Product.Name {
+			cs = append(cs, card{Section: section, Product: i.Product})
+		}
+		c := &cs[len(cs)-1]
+		c.InStock = c.InStock || i.IsAvailable()
+		c.Items = append(c.Items, i)
+		c.Fields = append(c.Fields, field{Name: i.Name, Value: value})
+	}
+	for _, i := range a.Available {
+		add("Now in stock", i, fmt.Sprintf("%s · %s", i.PriceString(), stockText(i)))
+	}
+	for _, c := range a.PriceChanges {
+		i := c.Item
+		add("Price drop", i, fmt.Sprintf("%s → %s (%s) · %s", c.Previous, i.PriceString(), c.Reason, stockText(i)))
+	}
+	return cs
+}
+
+func stockText(i item.Item) string {
+	if i.IsAvailable() {
+		return "In stock"
+	}
+	return "Out of stock"
+}
+
+// clip shortens s to at most n runes, marking the cut with an ellipsis.
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
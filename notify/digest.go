@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/telegram"
+)
+
+// QueuedMessage is messages held back during quiet hours, joined into one.
+type QueuedMessage struct {
+	Text string
+	// LastID is the ID of the last queued message in Text
+	LastID int64
+}
+
+// QueuedMessages joins messages held back during quiet hours into as few
+// as fit in telegram messages. A queued message too long to fit on its own
+// is truncated.
+func QueuedMessages(queued []database.QueuedAlert) []QueuedMessage {
+	header := "While you were away:\n"
+	var msgs []QueuedMessage
+	curr := QueuedMessage{Text: header}
+	for _, q := range queued {
+		entry := fmt.Sprintf("\n[%s]\n%s", q.Timestamp, q.Message)
+		entry = telegram.Truncate(entry, telegram.MaxMessageLength-len(header))
+		if curr.Text != header && len(curr.Text)+len(entry) > telegram.MaxMessageLength {
+			msgs = append(msgs, curr)
+			curr = QueuedMessage{Text: header}
+		}
+		curr.Text += entry
+		curr.LastID = q.ID
+	}
+	if curr.Text != header {
+		msgs = append(msgs, curr)
+	}
+	return msgs
+}
+
+// DigestText summarizes restocks, sellouts and price changes of watched
+// items, or returns "" if there were none. lookup rebuilds the item of a
+// change, for watched to match.
+func DigestText(period string, changes []database.StockChange, watched func(item.Item) bool, lookup func(database.StockRow) item.Item) string {
+	sections := []struct {
+		title   string
+		matches func(database.StockChange) bool
+		line    func(database.StockChange) string
+	}{
+		{
+			"Restocks",
+			database.StockChange.Restocked,
+			func(c database.StockChange) string {
+				return fmt.Sprintf("%s @ %s (%s)", c.ItemName, c.Price, c.Timestamp)
+			},
+		},
+		{
+			"Sellouts",
+			database.StockChange.SoldOut,
+			func(c database.StockChange) string {
+				return fmt.Sprintf("%s (%s)", c.ItemName, c.Timestamp)
+			},
+		},
+		{
+			"Price changes",
+			func(c database.StockChange) bool { return !c.Restocked() && !c.SoldOut() && c.PriceChanged() },
+			func(c database.StockChange) string {
+				return fmt.Sprintf("%s: %s -> %s (%s)", c.ItemName, c.PrevPrice, c.Price, c.Timestamp)
+			},
+		},
+	}
+
+	var parts []string
+	for _, section := range sections {
+		var groups []Group
+		for _, c := range changes {
+			i := lookup(c.StockRow)
+			if !section.matches(c) || !watched(i) {
+				continue
+			}
+			groups = addLine(groups, i.Product, section.line(c))
+		}
+		if len(groups) > 0 {
+			parts = append(parts, fmt.Sprintf("%s:\n%s", section.title, formatGroups(groups)))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	title := strings.Title(period)
+	return fmt.Sprintf("%s digest:\n\n%s", title, strings.Join(parts, "\n"))
+}
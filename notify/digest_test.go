@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/telegram"
+)
+
+func TestQueuedMessages(t *testing.T) {
+	if msgs := QueuedMessages(nil); len(msgs) != 0 {
+		t.Errorf("got %d messages for an empty queue", len(msgs))
+	}
+
+	line := strings.Repeat("x", 99) + "\n"
+	var queued []database.QueuedAlert
+	for id := int64(1); id <= 30; id++ {
+		queued = append(queued, database.QueuedAlert{ID: id, Message: strings.Repeat(line, 5)})
+	}
+	// One alert too long to send on its own
+	queued = append(queued, database.QueuedAlert{ID: 31, Message: strings.Repeat(line, 100)})
+
+	msgs := QueuedMessages(queued)
+	if len(msgs) < 2 {
+		t.Fatalf("got %d messages, want the queue split", len(msgs))
+	}
+	if last := msgs[len(msgs)-1].LastID; last != 31 {
+		t.Errorf("last message ends at %d, want 31", last)
+	}
+	entries := 0
+	for n, m := range msgs {
+		if len(m.Text) > telegram.MaxMessageLength {
+			t.Errorf("message %d is %d long", n, len(m.Text))
+		}
+		if !strings.HasPrefix(m.Text, "While you were away:\n") {
+			t.Errorf("message %d has no header", n)
+		}
+		if n > 0 && m.LastID <= msgs[n-1].LastID {
+			t.Errorf("message %d ends at %d, before message %d", n, m.LastID, n-1)
+		}
+		entries += strings.Count(m.Text, "\n[")
+	}
+	if entries != len(queued) {
+		t.Errorf("got %d queued alerts across messages, want %d", entries, len(queued))
+	}
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Discord message limits
+const (
+	discordEmbedsPerMessage = 10
+	discordFieldsPerEmbed   = 25
+	discordTitleLength      = 256
+	discordFieldNameLength  = 256
+	discordFieldValueLength = 1024
+	discordMessageLength    = 6000
+)
+
+// Discord posts alerts to a Discord incoming webhook as embeds, one per
+// product, split over as many messages as Discord's limits need.
+type Discord struct {
+	URL     string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+type discordMessage struct {
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+func NewDiscord(url string, timeout time.Duration, retries int) Discord {
+	return Discord{URL: url, Client: &http.Client{Timeout: timeout}, Retries: retries, Backoff: time.Second}
+}
+
+func (d Discord) Name() string {
+	return "discord"
+}
+
+func (d Discord) Target() string {
+	return redactURL(d.URL)
+}
+
+func (d Discord) Notify(a Alert) error {
+	for _, msg := range discordMessages(cards(a)) {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := postJSON(d.Client, d.URL, body, nil, d.Retries, d.Backoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discordMessages packs cards into embeds and messages within Discord's
+// limits, splitting products with too many items over several embeds.
+func discordMessages(cs []card) []discordMessage {
+	var embeds []discordEmbed
+	for _, c := range cs {
+		for start := 0; start < len(c.Fields); start += discordFieldsPerEmbed {
+			end := start + discordFieldsPerEmbed
+			if end > len(c.Fields) {
+				end = len(c.Fields)
+			}
+			e := discordEmbed{
+				Title:       clip(c.Product.Name, discordTitleLength),
+				URL:         c.Product.URL,
+				Description: c.Section,
+				Color:       c.color(),
+			}
+			for _, f := range c.Fields[start:end] {
+				e.Fields = append(e.Fields, discordField{
+					Name:  clip(f.Name, discordFieldNameLength),
+					Value: clip(f.Value, discordFieldValueLength),
+				})
+			}
+			embeds = append(embeds, e)
+		}
+	}
+
+	var msgs []discordMessage
+	length := 0
+	for _, e := range embeds {
+		n := embedLength(e)
+		if len(msgs) == 0 || len(msgs[len(msgs)-1].Embeds) == discordEmbedsPerMessage || length+n > discordMessageLength {
+			msgs = append(msgs, discordMessage{})
+			length = 0
+		}
+		msgs[len(msgs)-1].Embeds = append(msgs[len(msgs)-1].Embeds, e)
+		length += n
+	}
+	return msgs
+}
+
+// embedLength counts the characters Discord counts towards its message
+// limit
+func embedLength(e discordEmbed) int {
+	n := len([]rune(e.Title)) + len([]rune(e.Description))
+	for _, f := range e.Fields {
+		n += len([]rune(f.Name)) + len([]rune(f.Value))
+	}
+	return n
+}
@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email sends alerts as multipart plain text and html emails over SMTP.
+// Credentials are only sent over TLS, or to localhost.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	StartTLS bool
+	From     string
+	To       []string
+	// tls_config overrides the STARTTLS config, which verifies Host
+	tls_config *tls.Config
+}
+
+// emailTimeout bounds a whole SMTP conversation
+const emailTimeout = time.Minute
+
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+{{ range .Sections }}
+<h2>{{ .Title }}</h2>
+{{ range .Groups }}
+<h3><a href="{{ .Product.URL }}">{{ .Product.Name }}</a></h3>
+<ul>
+{{ range .Lines }}<li>{{ . }}</li>
+{{ end }}</ul>
+{{ end }}
+{{ end }}
+</body>
+</html>
+`))
+
+type emailSection struct {
+	Title  string
+	Groups []Group
+}
+
+func (e Email) Name() string {
+	return "email"
+}
+
+func (e Email) Target() string {
+	return strings.Join(e.To, ", ")
+}
+
+func (e Email) Notify(a Alert) error {
+	msg, err := e.message(a, time.Now())
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	conn, err := net.DialTimeout("tcp", addr, emailTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	c, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.StartTLS {
+		config := e.tls_config
+		if config == nil {
+			config = &tls.Config{ServerName: e.Host}
+		}
+		if err := c.StartTLS(config); err != nil {
+			return fmt.Errorf("starttls: %s", err)
+		}
+	}
+	if e.Username != "" {
+		auth := smtp.PlainAuth("", e.Username, e.Password, e.Host)
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("auth: %s", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("rcpt %s: %s", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// message builds the email, with the plain text part matching the
+// telegram message.
+func (e Email) message(a Alert, now time.Time) ([]byte, error) {
+	var text []string
+	var sections []emailSection
+	var subject []string
+	if len(a.Available) > 0 {
+		text = append(text, AvailableText(a.Available))
+		sections = append(sections, emailSection{
+			Title:  "Watched In Stock Items",
+			Groups: Groups(a.Available, availableLine),
+		})
+		subject = append(subject, plural(len(a.Available), "item")+" in stock")
+	}
+	if len(a.PriceChanges) > 0 {
+		text = append(text, PriceChangesText(a.PriceChanges))
+		items, line := priceChangeLines(a.PriceChanges)
+		sections = append(sections, emailSection{
+			Title:  "Watched Price Drops",
+			Groups: Groups(items, line),
+		})
+		subject = append(subject, plural(len(a.PriceChanges), "price drop"))
+	}
+	var html bytes.Buffer
+	if err := emailTemplate.Execute(&html, struct{ Sections []emailSection }{sections}); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	parts := multipart.NewWriter(&msg)
+	headers := []string{
+		"From: " + e.From,
+		"To: " + strings.Join(e.To, ", "),
+		"Subject: Gym stock: " + strings.Join(subject, ", "),
+		"Date: " + now.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	msg.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	bodies := []struct {
+		content_type string
+		body         string
+	}{
+		{"text/plain; charset=utf-8", strings.Join(text, "\n")},
+		{"text/html; charset=utf-8", html.String()},
+	}
+	for _, b := range bodies {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {b.content_type},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(strings.Replace(b.body, "\n", "\r\n", -1))); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
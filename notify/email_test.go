@@ -0,0 +1,327 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// smtpSession is what the fake server saw a client do
+type smtpSession struct {
+	// TLS is set once the client upgraded with STARTTLS
+	TLS bool
+	// Auth is the decoded AUTH PLAIN response, and AuthTLS whether it
+	// was sent over TLS
+	Auth    string
+	AuthTLS bool
+	From    string
+	To      []string
+	Data    string
+}
+
+// fakeSMTP serves one SMTP session on a local port, offering STARTTLS if
+// config is set. The session is sent on the channel once it ends.
+func fakeSMTP(t *testing.T, config *tls.Config) (int, <-chan smtpSession) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			close(sessions)
+			return
+		}
+		defer conn.Close()
+		sessions <- serveSMTP(conn, config)
+	}()
+	return l.Addr().(*net.TCPAddr).Port, sessions
+}
+
+func serveSMTP(conn net.Conn, config *tls.Config) smtpSession {
+	var s smtpSession
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP fake")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return s
+		}
+		verb, arg := line, ""
+		if n := strings.Index(line, " "); n >= 0 {
+			verb, arg = line[:n], line[n+1:]
+		}
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			extensions := []string{"localhost"}
+			if config != nil && !s.TLS {
+				extensions = append(extensions, "STARTTLS")
+			}
+			extensions = append(extensions, "AUTH PLAIN")
+			for n, e := range extensions {
+				sep := "-"
+				if n == len(extensions)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, e)
+			}
+		case "STARTTLS":
+			if config == nil || s.TLS {
+				text.PrintfLine("502 STARTTLS not offered")
+				continue
+			}
+			text.PrintfLine("220 Ready to start TLS")
+			tls_conn := tls.Server(conn, config)
+			if err := tls_conn.Handshake(); err != nil {
+				return s
+			}
+			text = textproto.NewConn(tls_conn)
+			s.TLS = true
+		case "AUTH":
+			fields := strings.Fields(arg)
+			if len(fields) != 2 || fields[0] != "PLAIN" {
+				text.PrintfLine("504 only AUTH PLAIN with a response")
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				text.PrintfLine("501 bad base64")
+				continue
+			}
+			s.Auth, s.AuthTLS = string(decoded), s.TLS
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			s.From = arg
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			s.To = append(s.To, arg)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return s
+			}
+			s.Data = string(data)
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return s
+		default:
+			text.PrintfLine("502 Unknown command")
+		}
+	}
+}
+
+// testCert makes a self-signed certificate for 127.0.0.1, and a pool
+// trusting it
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func testAlert() Alert {
+	rack := &product.Product{Name: "Titan T-3 Power Rack", URL: "https://example.com/rack", Brand: "Titan"}
+	plates := &product.Product{Name: "Rogue Olympic Plates", URL: "https://example.com/plates", Brand: "Rogue"}
+	return Alert{
+		Available: []item.Item{
+			{Product: rack, Name: `71" Height / 36" Depth`, Price: money.USD(41999), Availability: "In stock"},
+		},
+		PriceChanges: []watch.PriceChange{{
+			Item:     item.Item{Product: plates, Name: "45LB Pair", Price: money.USD(15000), Availability: "In stock"},
+			Previous: money.USD(18000),
+			Reason:   "price drop",
+		}},
+	}
+}
+
+func testEmail(port int) Email {
+	return Email{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "bot",
+		Password: "hunter2",
+		From:     "bot@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+	}
+}
+
+func waitSession(t *testing.T, sessions <-chan smtpSession) smtpSession {
+	select {
+	case s := <-sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake smtp server never finished")
+	}
+	return smtpSession{}
+}
+
+func TestEmailNotify(t *testing.T) {
+	port, sessions := fakeSMTP(t, nil)
+	e := testEmail(port)
+	if err := e.Notify(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	s := waitSession(t, sessions)
+
+	// Credentials may go in the clear to localhost only
+	if s.Auth != "\x00bot\x00hunter2" {
+		t.Errorf("auth %q, want bot's plain credentials", s.Auth)
+	}
+	if s.From != "FROM:<bot@example.com>" {
+		t.Errorf("mail %q", s.From)
+	}
+	if strings.Join(s.To, ",") != "TO:<a@example.com>,TO:<b@example.com>" {
+		t.Errorf("rcpts %q", s.To)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(s.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{
+		"From":         "bot@example.com",
+		"To":           "a@example.com, b@example.com",
+		"Subject":      "Gym stock: 1 item in stock, 1 price drop",
+		"Mime-Version": "1.0",
+	}
+	for k, want := range headers {
+		if got := msg.Header.Get(k); got != want {
+			t.Errorf("%s header %q, want %q", k, got, want)
+		}
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("bad Date header: %s", err)
+	}
+
+	media_type, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media_type != "multipart/alternative" {
+		t.Fatalf("content type %q, want multipart/alternative", media_type)
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	want_parts := []struct {
+		content_type string
+		contains     []string
+	}{
+		{"text/plain; charset=utf-8", []string{`71" Height / 36" Depth`, "45LB Pair: $180.00 -> $150.00 (price drop)"}},
+		{"text/html; charset=utf-8", []string{`<a href="https://example.com/rack">Titan T-3 Power Rack</a>`, "Watched Price Drops"}},
+	}
+	for _, want := range want_parts {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("reading %s part: %s", want.content_type, err)
+		}
+		if got := part.Header.Get("Content-Type"); got != want.content_type {
+			t.Errorf("part content type %q, want %q", got, want.content_type)
+		}
+		// The quoted-printable encoding is undone by the reader
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range want.contains {
+			if !strings.Contains(string(body), c) {
+				t.Errorf("%s part is missing %q:\n%s", want.content_type, c, body)
+			}
+		}
+	}
+	if _, err := parts.NextPart(); err == nil {
+		t.Error("got more than two parts")
+	}
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	cert, pool := testCert(t)
+	port, sessions := fakeSMTP(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	e := testEmail(port)
+	e.StartTLS = true
+	e.tls_config = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	if err := e.Notify(testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	s := waitSession(t, sessions)
+	if !s.TLS || !s.AuthTLS {
+		t.Errorf("session upgraded: %t, auth over tls: %t, want both", s.TLS, s.AuthTLS)
+	}
+	if s.Auth != "\x00bot\x00hunter2" {
+		t.Errorf("auth %q, want bot's plain credentials", s.Auth)
+	}
+	if s.Data == "" {
+		t.Error("no message was sent")
+	}
+}
+
+func TestEmailStartTLSUntrusted(t *testing.T) {
+	cert, _ := testCert(t)
+	port, sessions := fakeSMTP(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	e := testEmail(port)
+	e.StartTLS = true
+	err := e.Notify(testAlert())
+	if err == nil || !strings.HasPrefix(err.Error(), "starttls:") {
+		t.Fatalf("got %v, want a starttls error for a self-signed cert", err)
+	}
+	if s := waitSession(t, sessions); s.Auth != "" || s.Data != "" {
+		t.Error("credentials or mail were sent after a failed STARTTLS")
+	}
+}
+
+func TestEmailStartTLSUnsupported(t *testing.T) {
+	port, sessions := fakeSMTP(t, nil)
+	e := testEmail(port)
+	e.StartTLS = true
+	err := e.Notify(testAlert())
+	if err == nil || !strings.HasPrefix(err.Error(), "starttls:") {
+		t.Fatalf("got %v, want a starttls error from a server without it", err)
+	}
+	if s := waitSession(t, sessions); s.Auth != "" || s.Data != "" {
+		t.Error("credentials or mail were sent without STARTTLS")
+	}
+}
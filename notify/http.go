@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postJSON POSTs body, retrying network errors, 429s and 5xxs with
+// exponential backoff, or after the server's Retry-After if longer.
+func postJSON(client *http.Client, endpoint string, body []byte, headers map[string]string, retries int, backoff time.Duration) error {
+	return withRetries(retries, backoff, func() (bool, time.Duration, error) {
+		return postOnce(client, endpoint, body, headers)
+	})
+}
+
+// withRetries calls try until it succeeds, fails for good or runs out of
+// retries. try reports whether a failure is worth retrying and how long
+// the server asked to wait.
+func withRetries(retries int, backoff time.Duration, try func() (bool, time.Duration, error)) error {
+	for attempt := 0; ; attempt++ {
+		retry, retry_after, err := try()
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+		if attempt >= retries {
+			return fmt.Errorf("giving up after %d attempts: %s", attempt+1, err)
+		}
+		wait := backoff
+		if retry_after > wait {
+			wait = retry_after
+		}
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// postOnce sends one attempt, reporting whether a failure is worth
+// retrying and how long the server asked to wait
+func postOnce(client *http.Client, endpoint string, body []byte, headers map[string]string) (bool, time.Duration, error) {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gym-stock-bot")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if url_err, ok := err.(*url.Error); ok {
+			url_err.URL = redactURL(url_err.URL)
+		}
+		return true, 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, 0, nil
+	}
+	var retry_after time.Duration
+	if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+		retry_after = time.Duration(secs * float64(time.Second))
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, retry_after, fmt.Errorf("POST %s: %s", redactURL(endpoint), resp.Status)
+}
+
+// redactURL drops the path and query of a webhook url, which hold its
+// secret token, keeping the host to tell webhooks apart in logs.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(bad url)"
+	}
+	redacted := u.Scheme + "://" + u.Host
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		redacted += "/..."
+	}
+	return redacted
+}
@@ -0,0 +1,20 @@
+package notify
+
+import "testing"
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://discord.com/api/webhooks/123/secret", "https://discord.com/..."},
+		{"https://hooks.slack.com/services/T0/B0/secret", "https://hooks.slack.com/..."},
+		{"https://example.com/hook?token=secret", "https://example.com/..."},
+		{"http://localhost:8080/", "http://localhost:8080"},
+		{"not a url", "(bad url)"},
+	}
+	for _, test := range tests {
+		if got := redactURL(test.in); got != test.want {
+			t.Errorf("redactURL(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
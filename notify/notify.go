@@ -0,0 +1,177 @@
+// Package notify delivers stock alerts. Each destination, such as a
+// Telegram chat or a webhook, is a Notifier, and every delivery is
+// recorded in the db's deliveries table.
+package notify
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// Alert is what a run found worth telling someone about.
+type Alert struct {
+	// Available are watched items newly in stock
+	Available []item.Item
+	// PriceChanges are watched items whose price tripped a trigger
+	PriceChanges []watch.PriceChange
+	// Priority is each item's priority from the rules watching it, by
+	// item id
+	Priority map[string]int
+}
+
+// NewAlert builds an alert, taking priorities from rules.
+func NewAlert(rules watch.Rules, available []item.Item, changes []watch.PriceChange) Alert {
+	a := Alert{Available: available, PriceChanges: changes, Priority: map[string]int{}}
+	for _, i := range available {
+		a.Priority[i.ID()] = rules.Priority(i)
+	}
+	for _, c := range changes {
+		a.Priority[c.Item.ID()] = rules.Priority(c.Item)
+	}
+	return a
+}
+
+// PriorityOf returns an item's priority, or the default if unknown.
+func (a Alert) PriorityOf(i item.Item) int {
+	if p, ok := a.Priority[i.ID()]; ok {
+		return p
+	}
+	return watch.DefaultPriority
+}
+
+func (a Alert) Empty() bool {
+	return len(a.Available) == 0 && len(a.PriceChanges) == 0
+}
+
+type Notifier interface {
+	// Name is the kind of notifier, e.g. "telegram"
+	Name() string
+	// Target is where it delivers to, e.g. a chat id or url
+	Target() string
+	Notify(a Alert) error
+}
+
+// Deliver sends a non-empty alert and records the outcome.
+func Deliver(db *sql.DB, n Notifier, a Alert) error {
+	if a.Empty() {
+		return nil
+	}
+	err := n.Notify(a)
+	logDelivery(db, n, err)
+	return err
+}
+
+func logDelivery(db *sql.DB, n Notifier, err error) {
+	d := database.Delivery{Notifier: n.Name(), Target: n.Target()}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	database.LogDelivery(db, d)
+}
+
+// Merge combines alerts, keeping the first of each item and its highest
+// priority.
+func Merge(alerts ...Alert) Alert {
+	merged := Alert{Priority: map[string]int{}}
+	seen := map[string]bool{}
+	seen_changes := map[string]bool{}
+	for _, a := range alerts {
+		for id, p := range a.Priority {
+			if p > merged.Priority[id] {
+				merged.Priority[id] = p
+			}
+		}
+		for _, i := range a.Available {
+			if !seen[i.ID()] {
+				seen[i.ID()] = true
+				merged.Available = append(merged.Available, i)
+			}
+		}
+		for _, c := range a.PriceChanges {
+			if !seen_changes[c.Item.ID()] {
+				seen_changes[c.Item.ID()] = true
+				merged.PriceChanges = append(merged.PriceChanges, c)
+			}
+		}
+	}
+	return merged
+}
+
+// Group is a product's lines in an alert.
+type Group struct {
+	Product *product.Product
+	Lines   []string
+}
+
+// Groups gathers items under their products, in the order products
+// first appear.
+func Groups(items []item.Item, line func(item.Item) string) []Group {
+	var groups []Group
+	for _, i := range items {
+		groups = addLine(groups, i.Product, line(i))
+	}
+	return groups
+}
+
+func addLine(groups []Group, p *product.Product, line string) []Group {
+	for n := range groups {
+		if groups[n].Product.Name == p.Name {
+			groups[n].Lines = append(groups[n].Lines, line)
+			return groups
+		}
+	}
+	return append(groups, Group{Product: p, Lines: []string{line}})
+}
+
+// AvailableText lists newly available items for a plain text message.
+func AvailableText(items []item.Item) string {
+	return "Watched In Stock Items:\n" + FormatItems(items, availableLine)
+}
+
+// PriceChangesText lists price changes for a plain text message.
+func PriceChangesText(changes []watch.PriceChange) string {
+	items, line := priceChangeLines(changes)
+	return "Watched Price Drops:\n" + FormatItems(items, line)
+}
+
+func availableLine(i item.Item) string {
+	return i.String()
+}
+
+func priceChangeLines(changes []watch.PriceChange) ([]item.Item, func(item.Item) string) {
+	reasons := map[string]watch.PriceChange{}
+	var items []item.Item
+	for _, c := range changes {
+		reasons[c.Item.ID()] = c
+		items = append(items, c.Item)
+	}
+	return items, func(i item.Item) string {
+		c := reasons[i.ID()]
+		return fmt.Sprintf("%s: %s -> %s (%s)", i.Name, c.Previous, i.PriceString(), c.Reason)
+	}
+}
+
+// FormatItems lists items grouped under their product's name and link.
+func FormatItems(items []item.Item, line func(item.Item) string) string {
+	return formatGroups(Groups(items, line))
+}
+
+func formatGroups(groups []Group) string {
+	msg := ""
+	for n, g := range groups {
+		if n > 0 {
+			msg += "\n"
+		}
+		msg += fmt.Sprintf("%s:\n", g.Product.Name)
+		msg += fmt.Sprintf("Link: %s\n", g.Product.URL)
+		for _, l := range g.Lines {
+			msg += fmt.Sprintf("> %s\n", l)
+		}
+	}
+	return msg
+}
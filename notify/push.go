@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// pushMessage is one product's part of an alert, for push servers that
+// show a short notification per message.
+type pushMessage struct {
+	Title    string
+	Message  string
+	Priority int
+	URL      string
+	Tags     []string
+}
+
+// pushMessages returns a message per product and section of the alert,
+// at the highest priority of its items.
+func pushMessages(a Alert) []pushMessage {
+	var msgs []pushMessage
+	for _, c := range cards(a) {
+		m := pushMessage{
+			Title:    fmt.Sprintf("%s: %s", c.Section, c.Product.Name),
+			Priority: watch.MinPriority,
+			URL:      c.Product.URL,
+			Tags:     []string{strings.ToLower(c.Product.Brand)},
+		}
+		if c.InStock {
+			m.Tags = append(m.Tags, "white_check_mark")
+		}
+		var lines []string
+		for _, f := range c.Fields {
+			lines = append(lines, fmt.Sprintf("%s: %s", f.Name, f.Value))
+		}
+		m.Message = strings.Join(lines, "\n")
+		for _, i := range c.Items {
+			if p := a.PriorityOf(i); p > m.Priority {
+				m.Priority = p
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Ntfy publishes alerts to an ntfy topic, e.g. https://ntfy.sh/my-gym,
+// with a message per product that opens the product page when tapped.
+type Ntfy struct {
+	// URL is the topic's url
+	URL     string
+	Token   string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+type ntfyMessage struct {
+	Topic    string       `json:"topic"`
+	Title    string       `json:"title"`
+	Message  string       `json:"message"`
+	Priority int          `json:"priority"`
+	Tags     []string     `json:"tags"`
+	Click    string       `json:"click,omitempty"`
+	Actions  []ntfyAction `json:"actions,omitempty"`
+}
+
+type ntfyAction struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+}
+
+// NewNtfy checks topic_url is a topic's url, so a bad one is found before
+// the first alert.
+func NewNtfy(topic_url, token string, timeout time.Duration, retries int) (Ntfy, error) {
+	if _, _, err := ntfyTopic(topic_url); err != nil {
+		return Ntfy{}, err
+	}
+	return Ntfy{
+		URL:     topic_url,
+		Token:   token,
+		Client:  &http.Client{Timeout: timeout},
+		Retries: retries,
+		Backoff: time.Second,
+	}, nil
+}
+
+func (n Ntfy) Name() string {
+	return "ntfy"
+}
+
+// Target leaves out the topic, as anyone who knows it can read the alerts
+func (n Ntfy) Target() string {
+	return redactURL(n.URL)
+}
+
+// Notify publishes as json to the server's root, which takes the topic in
+// the body.
+func (n Ntfy) Notify(a Alert) error {
+	root, topic, err := ntfyTopic(n.URL)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	if n.Token != "" {
+		headers["Authorization"] = "Bearer " + n.Token
+	}
+	for _, m := range pushMessages(a) {
+		body, err := json.Marshal(ntfyMessage{
+			Topic:    topic,
+			Title:    m.Title,
+			Message:  m.Message,
+			Priority: m.Priority,
+			Tags:     m.Tags,
+			Click:    m.URL,
+			Actions:  []ntfyAction{{Action: "view", Label: "Open product page", URL: m.URL}},
+		})
+		if err != nil {
+			return err
+		}
+		if err := postJSON(n.Client, root, body, headers, n.Retries, n.Backoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ntfyTopic splits a topic's url into the server's root and the topic
+func ntfyTopic(topic_url string) (string, string, error) {
+	u, err := url.Parse(topic_url)
+	if err != nil {
+		// The error would repeat the url
+		return "", "", fmt.Errorf("bad ntfy url")
+	}
+	topic := strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return "", "", fmt.Errorf("ntfy url %q should be a topic, e.g. https://ntfy.sh/my-topic", redactURL(topic_url))
+	}
+	u.Path = "/"
+	return u.String(), topic, nil
+}
+
+// Gotify sends alerts to a Gotify server as an application, with a
+// message per product that opens the product page when clicked. Gotify
+// has no tags, so the brand leads the title.
+type Gotify struct {
+	// URL is the server's url
+	URL string
+	// Token is the application token
+	Token   string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+type gotifyMessage struct {
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras"`
+}
+
+func NewGotify(server_url, token string, timeout time.Duration, retries int) Gotify {
+	return Gotify{
+		URL:     server_url,
+		Token:   token,
+		Client:  &http.Client{Timeout: timeout},
+		Retries: retries,
+		Backoff: time.Second,
+	}
+}
+
+func (g Gotify) Name() string {
+	return "gotify"
+}
+
+func (g Gotify) Target() string {
+	return g.URL
+}
+
+func (g Gotify) Notify(a Alert) error {
+	endpoint := strings.TrimSuffix(g.URL, "/") + "/message"
+	// The token goes in a header, keeping it out of urls in errors
+	headers := map[string]string{"X-Gotify-Key": g.Token}
+	for _, m := range pushMessages(a) {
+		body, err := json.Marshal(gotifyMessage{
+			Title:   fmt.Sprintf("[%s] %s", m.Tags[0], m.Title),
+			Message: m.Message,
+			// Gotify priorities run from 0 to 10
+			Priority: m.Priority * 2,
+			Extras: map[string]interface{}{
+				"client::notification": map[string]interface{}{
+					"click": map[string]string{"url": m.URL},
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := postJSON(g.Client, endpoint, body, headers, g.Retries, g.Backoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
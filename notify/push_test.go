@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewNtfy(t *testing.T) {
+	n, err := NewNtfy("https://ntfy.sh/secret-topic", "", time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := n.Target(); strings.Contains(target, "secret") {
+		t.Errorf("target %q gives away the topic", target)
+	}
+	root, topic, err := ntfyTopic(n.URL)
+	if root != "https://ntfy.sh/" || topic != "secret-topic" || err != nil {
+		t.Errorf("got %q, %q, %v", root, topic, err)
+	}
+
+	for _, u := range []string{"https://ntfy.sh", "https://ntfy.sh/", "https://ntfy.sh/a/secret", "ntfy.sh/secret", "%zz/secret"} {
+		_, err := NewNtfy(u, "", time.Second, 0)
+		if err == nil {
+			t.Errorf("NewNtfy(%q) should fail", u)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("NewNtfy(%q): %q gives away the topic", u, err)
+		}
+	}
+}
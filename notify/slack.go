@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Slack message limits
+const (
+	slackBlocksPerMessage = 50
+	slackFieldsPerSection = 10
+	slackTextLength       = 3000
+	slackFieldLength      = 2000
+	// Slack allows about one message a second per webhook
+	slackMessageDelay = time.Second
+)
+
+// Slack posts alerts to a Slack incoming webhook as attachments of
+// blocks, one per product, colored by stock state.
+type Slack struct {
+	URL     string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func NewSlack(url string, timeout time.Duration, retries int) Slack {
+	return Slack{URL: url, Client: &http.Client{Timeout: timeout}, Retries: retries, Backoff: time.Second}
+}
+
+func (s Slack) Name() string {
+	return "slack"
+}
+
+func (s Slack) Target() string {
+	return redactURL(s.URL)
+}
+
+func (s Slack) Notify(a Alert) error {
+	for n, msg := range slackMessages(cards(a)) {
+		if n > 0 {
+			time.Sleep(slackMessageDelay)
+		}
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := postJSON(s.Client, s.URL, body, nil, s.Retries, s.Backoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// slackMessages packs cards into attachments and messages within Slack's
+// limits. Each card is a header section linking to the product, then
+// sections of up to ten item fields.
+func slackMessages(cs []card) []slackMessage {
+	var msgs []slackMessage
+	blocks := 0
+	for _, c := range cs {
+		a := slackAttachment{Color: fmt.Sprintf("#%06x", c.color())}
+		header := fmt.Sprintf("*<%s|%s>*\n%s", c.Product.URL, slackEscape(c.Product.Name), c.Section)
+		a.Blocks = append(a.Blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: clip(header, slackTextLength)},
+		})
+		for start := 0; start < len(c.Fields); start += slackFieldsPerSection {
+			end := start + slackFieldsPerSection
+			if end > len(c.Fields) {
+				end = len(c.Fields)
+			}
+			section := slackBlock{Type: "section"}
+			for _, f := range c.Fields[start:end] {
+				text := fmt.Sprintf("*%s*\n%s", slackEscape(f.Name), slackEscape(f.Value))
+				section.Fields = append(section.Fields, slackText{Type: "mrkdwn", Text: clip(text, slackFieldLength)})
+			}
+			a.Blocks = append(a.Blocks, section)
+		}
+		if len(a.Blocks) > slackBlocksPerMessage {
+			a.Blocks = a.Blocks[:slackBlocksPerMessage]
+		}
+
+		if len(msgs) == 0 || blocks+len(a.Blocks) > slackBlocksPerMessage {
+			msgs = append(msgs, slackMessage{})
+			blocks = 0
+		}
+		m := &msgs[len(msgs)-1]
+		m.Attachments = append(m.Attachments, a)
+		blocks += len(a.Blocks)
+	}
+	for n := range msgs {
+		msgs[n].Text = slackFallback(msgs[n])
+	}
+	return msgs
+}
+
+// slackFallback is the notification text shown where blocks are not
+func slackFallback(m slackMessage) string {
+	return fmt.Sprintf("Gym stock alert: %s", plural(len(m.Attachments), "product"))
+}
+
+// slackEscape escapes the characters Slack treats as markup
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,252 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/maxtrussell/gym-stock-bot/analytics"
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/telegram"
+)
+
+// Telegram sends alerts to a chat, with available items and price changes
+// as separate messages.
+type Telegram struct {
+	APIToken string
+	ChatID   int64
+	Client   *http.Client
+	Retries  int
+	Backoff  time.Duration
+}
+
+func NewTelegram(api_token string, chat_id int64, timeout time.Duration, retries int) Telegram {
+	return Telegram{
+		APIToken: api_token,
+		ChatID:   chat_id,
+		Client:   &http.Client{Timeout: timeout},
+		Retries:  retries,
+		Backoff:  time.Second,
+	}
+}
+
+func (t Telegram) Name() string {
+	return "telegram"
+}
+
+func (t Telegram) Target() string {
+	return strconv.FormatInt(t.ChatID, 10)
+}
+
+func (t Telegram) Notify(a Alert) error {
+	for _, msg := range t.Messages(a) {
+		if _, err := t.send(msg, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Messages returns the messages an alert is sent as.
+func (t Telegram) Messages(a Alert) []string {
+	var msgs []string
+	if len(a.Available) > 0 {
+		msgs = append(msgs, AvailableText(a.Available))
+	}
+	if len(a.PriceChanges) > 0 {
+		msgs = append(msgs, PriceChangesText(a.PriceChanges))
+	}
+	return msgs
+}
+
+// DeliverAlert is Deliver with buttons to act on each item, keeping the
+// message about available items for follow-ups when they sell out.
+func (t Telegram) DeliverAlert(db *sql.DB, a Alert) error {
+	if len(a.Available) > 0 {
+		msg := AvailableText(a.Available)
+		keyboard := telegram.AlertKeyboard(db, t.ChatID, a.Available)
+		message_id, err := t.deliver(db, msg, keyboard)
+		if err != nil {
+			return err
+		}
+		keyboard_json := ""
+		if keyboard != nil {
+			b, err := json.Marshal(keyboard)
+			if err != nil {
+				return err
+			}
+			keyboard_json = string(b)
+		}
+		for _, i := range a.Available {
+			database.SaveSentMessage(db, database.SentMessage{
+				ChatID:    t.ChatID,
+				ItemID:    i.ID(),
+				MessageID: message_id,
+				Text:      msg,
+				Line:      availableLine(i),
+				Keyboard:  keyboard_json,
+			})
+		}
+	}
+	if len(a.PriceChanges) > 0 {
+		var items []item.Item
+		for _, c := range a.PriceChanges {
+			items = append(items, c.Item)
+		}
+		keyboard := telegram.AlertKeyboard(db, t.ChatID, items)
+		if _, err := t.deliver(db, PriceChangesText(a.PriceChanges), keyboard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverText sends a message that is not an alert, such as a digest,
+// and records the outcome.
+func (t Telegram) DeliverText(db *sql.DB, msg string) (int64, error) {
+	return t.deliver(db, msg, nil)
+}
+
+func (t Telegram) deliver(db *sql.DB, msg string, keyboard *tgbot.InlineKeyboardMarkup) (int64, error) {
+	message_id, err := t.send(msg, keyboard)
+	logDelivery(db, t, err)
+	return message_id, err
+}
+
+// Strike edits the messages that told the chat items were in stock, to
+// strike through those that sold out. Items without such a message, or
+// whose message could not be edited, are returned for a follow-up message
+// instead. Struck items are marked sold out.
+func (t Telegram) Strike(db *sql.DB, items []item.Item, now time.Time) ([]item.Item, error) {
+	var rest []item.Item
+	by_message := map[int64][]item.Item{}
+	for _, i := range items {
+		sent, ok := database.SentMessageFor(db, t.ChatID, i.ID())
+		if !ok || sent.SoldOut {
+			rest = append(rest, i)
+			continue
+		}
+		by_message[sent.MessageID] = append(by_message[sent.MessageID], i)
+	}
+
+	var first_err error
+	for message_id, struck := range by_message {
+		if err := t.strike(db, message_id, struck, now); err != nil {
+			rest = append(rest, struck...)
+			if first_err == nil {
+				first_err = err
+			}
+			continue
+		}
+		t.MarkSoldOut(db, struck)
+	}
+	return rest, first_err
+}
+
+// strike edits a message to strike through its items that sold out
+// before, and items.
+func (t Telegram) strike(db *sql.DB, message_id int64, items []item.Item, now time.Time) error {
+	fmt.Println()
+	fmt.Printf("Editing message %d in %d...\n", message_id, t.ChatID)
+	sent := database.MessageItems(db, t.ChatID, message_id)
+	for n := range sent {
+		for _, i := range items {
+			if sent[n].ItemID == i.ID() {
+				sent[n].SoldOut = true
+			}
+		}
+	}
+	var keyboard *tgbot.InlineKeyboardMarkup
+	if len(sent) > 0 && sent[0].Keyboard != "" {
+		keyboard = &tgbot.InlineKeyboardMarkup{}
+		if err := json.Unmarshal([]byte(sent[0].Keyboard), keyboard); err != nil {
+			return err
+		}
+	}
+	err := t.call(func() error {
+		return telegram.EditMessageText(t.Client, t.APIToken, t.Target(), message_id, struckText(sent, now), keyboard)
+	})
+	logDelivery(db, t, err)
+	return err
+}
+
+// FollowUpText lists items that sold out again, with how long they were in
+// stock when the chat was sent a message about them.
+func (t Telegram) FollowUpText(db *sql.DB, items []item.Item, now time.Time) string {
+	in_stock_for := map[string]time.Duration{}
+	for _, i := range items {
+		if sent, ok := database.SentMessageFor(db, t.ChatID, i.ID()); ok && !sent.SoldOut {
+			in_stock_for[i.ID()] = now.Sub(sent.Sent)
+		}
+	}
+	return "Sold Out Again:\n" + FormatItems(items, func(i item.Item) string {
+		if d, ok := in_stock_for[i.ID()]; ok {
+			return fmt.Sprintf("%s, in stock for %s", i.Name, analytics.FormatDuration(d))
+		}
+		return i.Name
+	})
+}
+
+// MarkSoldOut marks the messages about items as followed up on.
+func (t Telegram) MarkSoldOut(db *sql.DB, items []item.Item) {
+	for _, i := range items {
+		database.MarkSoldOut(db, t.ChatID, i.ID())
+	}
+}
+
+// struckText is a message as html, with the lines of sold out items
+// struck through.
+func struckText(items []database.SentMessage, now time.Time) string {
+	if len(items) == 0 {
+		return ""
+	}
+	sold_out := map[string]string{}
+	for _, m := range items {
+		if m.SoldOut {
+			sold_out["> "+m.Line] = analytics.FormatDuration(now.Sub(m.Sent))
+		}
+	}
+	lines := strings.Split(items[0].Text, "\n")
+	for n, line := range lines {
+		escaped := html.EscapeString(line)
+		if d, ok := sold_out[line]; ok {
+			escaped = fmt.Sprintf("<s>%s</s> sold out after %s", escaped, d)
+		}
+		lines[n] = escaped
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t Telegram) send(msg string, keyboard *tgbot.InlineKeyboardMarkup) (int64, error) {
+	// Telegram rejects long messages, which would otherwise fail every run
+	msg = telegram.Truncate(msg, telegram.MaxMessageLength)
+	fmt.Println()
+	fmt.Printf("Sending notification to %d...\n", t.ChatID)
+	fmt.Println(msg)
+	var message_id int64
+	err := t.call(func() error {
+		var err error
+		message_id, err = telegram.SendMessage(t.Client, t.APIToken, t.Target(), msg, keyboard)
+		return err
+	})
+	return message_id, err
+}
+
+// call makes a bot api call, retrying it like the other notifiers' posts
+func (t Telegram) call(f func() error) error {
+	return withRetries(t.Retries, t.Backoff, func() (bool, time.Duration, error) {
+		err := f()
+		if tg_err, ok := err.(*telegram.Error); ok {
+			return tg_err.Temporary(), tg_err.RetryAfter, err
+		}
+		return false, 0, err
+	})
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirect sends every request to a test server, in place of the bot api
+type redirect struct {
+	to *url.URL
+}
+
+func (r redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = r.to.Scheme
+	req.URL.Host = r.to.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeBotAPI answers sendMessage with replies in turn, repeating the last.
+// Call the returned func to close the server.
+func fakeBotAPI(t *testing.T, replies ...func(w http.ResponseWriter)) (Telegram, *int32, func()) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/sendMessage") {
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(replies) {
+			n = len(replies) - 1
+		}
+		replies[n](w)
+	}))
+	to, _ := url.Parse(server.URL)
+
+	n := NewTelegram("token", 42, time.Second, 2)
+	n.Client.Transport = redirect{to}
+	n.Backoff = time.Millisecond
+	return n, &calls, server.Close
+}
+
+func reply(status int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
+
+var sentReply = reply(http.StatusOK, `{"ok":true,"result":{"message_id":7}}`)
+
+func TestTelegramSend(t *testing.T) {
+	n, calls, done := fakeBotAPI(t, sentReply)
+	defer done()
+	id, err := n.send("hi", nil)
+	if err != nil || id != 7 {
+		t.Fatalf("got %d, %v, want message 7", id, err)
+	}
+	if *calls != 1 {
+		t.Errorf("made %d calls, want 1", *calls)
+	}
+}
+
+func TestTelegramRetries(t *testing.T) {
+	n, calls, done := fakeBotAPI(t,
+		reply(http.StatusTooManyRequests, `{"ok":false,"description":"Too Many Requests","parameters":{"retry_after":0}}`),
+		reply(http.StatusBadGateway, `not json`),
+		sentReply,
+	)
+	defer done()
+	id, err := n.send("hi", nil)
+	if err != nil || id != 7 {
+		t.Fatalf("got %d, %v, want message 7 after retries", id, err)
+	}
+	if *calls != 3 {
+		t.Errorf("made %d calls, want 3", *calls)
+	}
+}
+
+func TestTelegramGivesUp(t *testing.T) {
+	n, calls, done := fakeBotAPI(t, reply(http.StatusInternalServerError, `{"ok":false,"description":"Internal Server Error"}`))
+	defer done()
+	_, err := n.send("hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Fatalf("got %v, want giving up", err)
+	}
+	if *calls != 3 {
+		t.Errorf("made %d calls, want 3", *calls)
+	}
+}
+
+func TestTelegramNoRetryOnBadRequest(t *testing.T) {
+	n, calls, done := fakeBotAPI(t, reply(http.StatusBadRequest, `{"ok":false,"description":"Bad Request: chat not found"}`))
+	defer done()
+	_, err := n.send("hi", nil)
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Fatalf("got %v, want the api's description", err)
+	}
+	if *calls != 1 {
+		t.Errorf("made %d calls, want 1", *calls)
+	}
+}
+
+func TestTelegramTimeout(t *testing.T) {
+	hang := func(w http.ResponseWriter) { time.Sleep(300 * time.Millisecond) }
+	n, calls, done := fakeBotAPI(t, hang)
+	defer done()
+	n.Client.Timeout = 50 * time.Millisecond
+	n.Retries = 1
+	start := time.Now()
+	_, err := n.send("hi", nil)
+	if err == nil {
+		t.Fatal("want a timeout")
+	}
+	if strings.Contains(err.Error(), "token") {
+		t.Errorf("error leaks the bot token: %s", err)
+	}
+	if *calls != 2 {
+		t.Errorf("made %d calls, want 2", *calls)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %s, the timeout was not applied", d)
+	}
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+)
+
+// SignatureHeader holds "sha256=" and the hex HMAC-SHA256 of the body,
+// keyed with the webhook secret.
+const SignatureHeader = "X-Gym-Stock-Signature"
+
+// Webhook POSTs alerts as JSON, retrying network errors, 429s and 5xxs
+// with exponential backoff.
+type Webhook struct {
+	URL     string
+	Secret  string
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+type webhookPayload struct {
+	Event        string               `json:"event"`
+	Timestamp    string               `json:"timestamp"`
+	Available    []webhookItem        `json:"available"`
+	PriceChanges []webhookPriceChange `json:"price_changes"`
+}
+
+type webhookItem struct {
+	ID         string `json:"id"`
+	Product    string `json:"product"`
+	Item       string `json:"item"`
+	Brand      string `json:"brand"`
+	URL        string `json:"url"`
+	InStock    bool   `json:"in_stock"`
+	Price      string `json:"price"`
+	PriceCents int64  `json:"price_cents,omitempty"`
+	Currency   string `json:"currency,omitempty"`
+}
+
+type webhookPriceChange struct {
+	webhookItem
+	PreviousPrice      string `json:"previous_price"`
+	PreviousPriceCents int64  `json:"previous_price_cents,omitempty"`
+	Reason             string `json:"reason"`
+}
+
+func NewWebhook(url, secret string, timeout time.Duration, retries int) Webhook {
+	return Webhook{
+		URL:     url,
+		Secret:  secret,
+		Client:  &http.Client{Timeout: timeout},
+		Retries: retries,
+		Backoff: time.Second,
+	}
+}
+
+func (w Webhook) Name() string {
+	return "webhook"
+}
+
+func (w Webhook) Target() string {
+	return redactURL(w.URL)
+}
+
+func (w Webhook) Notify(a Alert) error {
+	payload := webhookPayload{
+		Event:        "stock_alert",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Available:    []webhookItem{},
+		PriceChanges: []webhookPriceChange{},
+	}
+	for _, i := range a.Available {
+		payload.Available = append(payload.Available, newWebhookItem(i))
+	}
+	for _, c := range a.PriceChanges {
+		payload.PriceChanges = append(payload.PriceChanges, webhookPriceChange{
+			webhookItem:        newWebhookItem(c.Item),
+			PreviousPrice:      c.Previous.String(),
+			PreviousPriceCents: c.Previous.Cents,
+			Reason:             c.Reason,
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	if w.Secret != "" {
+		headers[SignatureHeader] = "sha256=" + Sign(w.Secret, body)
+	}
+	return postJSON(w.Client, w.URL, body, headers, w.Retries, w.Backoff)
+}
+
+// Sign returns the hex HMAC-SHA256 of body, for receivers to check the
+// signature header against.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookItem(i item.Item) webhookItem {
+	return webhookItem{
+		ID:         i.ID(),
+		Product:    i.Product.Name,
+		Item:       i.Name,
+		Brand:      i.Product.Brand,
+		URL:        i.Product.URL,
+		InStock:    i.IsAvailable(),
+		Price:      i.PriceString(),
+		PriceCents: i.Price.Cents,
+		Currency:   i.Price.Currency,
+	}
+}
@@ -0,0 +1,65 @@
+// Package quiet handles a subscriber's quiet hours, written like
+// "22:00-07:00" in server local time, during which alerts are held back.
+package quiet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hours is a daily span, in minutes since midnight. A span whose end is
+// before its start runs past midnight.
+type Hours struct {
+	Start int
+	End   int
+}
+
+// Parse reads "HH:MM-HH:MM". An empty string means no quiet hours.
+func Parse(s string) (*Hours, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad quiet hours %q, want e.g. 22:00-07:00", s)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("quiet hours %q are empty", s)
+	}
+	return &Hours{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t falls in the quiet hours. Nil hours contain
+// nothing.
+func (h *Hours) Contains(t time.Time) bool {
+	if h == nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if h.Start < h.End {
+		return m >= h.Start && m < h.End
+	}
+	return m >= h.Start || m < h.End
+}
+
+func (h *Hours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", h.Start/60, h.Start%60, h.End/60, h.End%60)
+}
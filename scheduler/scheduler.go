@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+// Scheduler decides when each product is next scraped. A product's own
+// interval wins over its vendor's, which wins over the default.
+type Scheduler struct {
+	Interval        time.Duration
+	Jitter          time.Duration
+	VendorIntervals map[string]time.Duration
+
+	next map[string]time.Time
+}
+
+func New(interval, jitter time.Duration, vendor_intervals map[string]time.Duration) *Scheduler {
+	return &Scheduler{
+		Interval:        interval,
+		Jitter:          jitter,
+		VendorIntervals: vendor_intervals,
+		next:            map[string]time.Time{},
+	}
+}
+
+func (s *Scheduler) IntervalFor(p product.Product) time.Duration {
+	if p.Interval != "" {
+		// Already checked by product.Load
+		d, _ := time.ParseDuration(p.Interval)
+		return d
+	}
+	if d, ok := s.VendorIntervals[p.Brand]; ok {
+		return d
+	}
+	return s.Interval
+}
+
+// Due returns the products whose next scrape time has passed. Products
+// that have never been scraped are always due.
+func (s *Scheduler) Due(products []product.Product, now time.Time) []product.Product {
+	var due []product.Product
+	for _, p := range products {
+		if next, ok := s.next[p.Name]; !ok || !now.Before(next) {
+			due = append(due, p)
+		}
+	}
+	return due
+}
+
+// Scraped records that p was scraped at t and picks its next scrape time.
+func (s *Scheduler) Scraped(p product.Product, t time.Time) {
+	next := t.Add(s.IntervalFor(p))
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+	s.next[p.Name] = next
+}
+
+// Next returns the earliest scheduled scrape, or the zero time if nothing
+// has been scheduled yet.
+func (s *Scheduler) Next() time.Time {
+	var next time.Time
+	for _, t := range s.next {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// ParseVendorIntervals parses a list like "Rogue=5m,RepFitness=15m".
+func ParseVendorIntervals(s string) (map[string]time.Duration, error) {
+	intervals := map[string]time.Duration{}
+	if s == "" {
+		return intervals, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad vendor interval %q, want Brand=duration", part)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad vendor interval %q: %s", part, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("bad vendor interval %q: must be positive", part)
+		}
+		intervals[kv[0]] = d
+	}
+	return intervals, nil
+}
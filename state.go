@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// import_state moves watches and run state from the text files older
+// versions kept into the db, owned by chat_id, or unowned if it is 0.
+// Rules the chat already has are skipped. Imported files are renamed so
+// they are only imported once.
+func import_state(db *sql.DB, chat_id int64) {
+	rules, err := watch.Load("watched.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	existing := map[string]bool{}
+	for _, w := range database.Watches(db, chat_id) {
+		existing[w.Rule] = true
+	}
+	imported := 0
+	for _, r := range rules {
+		if existing[r.Text] {
+			continue
+		}
+		existing[r.Text] = true
+		database.AddWatch(db, chat_id, r.Text)
+		imported++
+	}
+
+	notified := read_lines("notified_items.txt")
+	last_in_stock := map[string]time.Time{}
+	for n, line := range read_lines("last_in_stock.txt") {
+		line_parts := strings.Split(line, " :: ")
+		if len(line_parts) != 2 {
+			fmt.Printf("last_in_stock.txt:%d: skipping malformed line %q\n", n+1, line)
+			continue
+		}
+		t, err := time.ParseInLocation("Jan 02, 2006 15:04", line_parts[1], time.Local)
+		if err != nil {
+			fmt.Printf("last_in_stock.txt:%d: skipping bad time %q\n", n+1, line_parts[1])
+			continue
+		}
+		last_in_stock[line_parts[0]] = t
+	}
+
+	database.ImportState(db, chat_id, notified, last_in_stock)
+	for _, path := range []string{"watched.txt", "notified_items.txt", "last_in_stock.txt"} {
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, path+".imported"); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	fmt.Printf(
+		"Imported %d watches, %d notified items and %d last in stock times\n",
+		imported,
+		len(notified),
+		len(last_in_stock),
+	)
+}
+
+func read_lines(path string) []string {
+	var lines []string
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return lines
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,262 @@
+package telegram
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxtrussell/gym-stock-bot/analytics"
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/quiet"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// Telegram rejects messages longer than this
+const MaxMessageLength = 4096
+
+const helpText = `Commands:
+/start - get alerts for your watches
+/stop - stop getting alerts
+/watch <rule> - watch items, e.g. /watch brand:Rogue product:"Ohio Power Bar" max:$350
+/unwatch <id> - stop a watch
+/watches - list watches
+/instock - list items in stock
+/status <item> - current stock of matching items
+/history <item> - stock history of an item
+/quiet <HH:MM-HH:MM|off> - hold alerts during quiet hours and send them after
+/digest <daily|weekly|off> - get a summary of restocks, sellouts and price changes
+/followups <message|edit|off> - when alerted items sell out again, send a message or strike them through`
+
+func startCommand(db *sql.DB, chat_id int64) string {
+	if !database.Subscribe(db, chat_id) {
+		return "You are already subscribed"
+	}
+	return "Subscribed! You will get alerts for your watches.\n\n" + helpText
+}
+
+func stopCommand(db *sql.DB, chat_id int64) string {
+	if !database.Unsubscribe(db, chat_id) {
+		return "You are not subscribed"
+	}
+	return "Unsubscribed. Your watches are kept, /start to get alerts again"
+}
+
+func watchCommand(db *sql.DB, chat_id int64, args string) string {
+	if args == "" {
+		return "Usage: /watch <rule>"
+	}
+	if _, err := watch.Parse(args); err != nil {
+		return fmt.Sprintf("Bad rule: %s", err)
+	}
+	id := database.AddWatch(db, chat_id, args)
+	msg := fmt.Sprintf("Watching #%d: %s", id, args)
+	if !database.IsSubscribed(db, chat_id) {
+		msg += "\nSend /start to get alerts"
+	}
+	return msg
+}
+
+func unwatchCommand(db *sql.DB, chat_id int64, args string) string {
+	id, err := strconv.ParseInt(strings.TrimPrefix(args, "#"), 10, 64)
+	if err != nil {
+		return "Usage: /unwatch <id>, see /watches for ids"
+	}
+	if !database.RemoveWatch(db, chat_id, id) {
+		return fmt.Sprintf("No watch #%d", id)
+	}
+	return fmt.Sprintf("Stopped watch #%d", id)
+}
+
+func watchesCommand(db *sql.DB, chat_id int64) string {
+	watches := database.Watches(db, chat_id)
+	if len(watches) == 0 {
+		return "No watches, add one with /watch <rule>"
+	}
+	msg := "Watches:\n"
+	for _, w := range watches {
+		msg += fmt.Sprintf("#%d: %s\n", w.ID, w.Rule)
+	}
+	return msg
+}
+
+func quietCommand(db *sql.DB, chat_id int64, args string) string {
+	if !database.IsSubscribed(db, chat_id) {
+		return "You are not subscribed, send /start first"
+	}
+	if args == "" {
+		settings := database.SubscriberSettings(db, chat_id)
+		if settings.Quiet == "" {
+			return "No quiet hours. Usage: /quiet 22:00-07:00"
+		}
+		return fmt.Sprintf("Quiet hours: %s, /quiet off to remove", settings.Quiet)
+	}
+	if args == "off" {
+		database.SetQuiet(db, chat_id, "")
+		return "Quiet hours removed"
+	}
+	hours, err := quiet.Parse(args)
+	if err != nil {
+		return fmt.Sprintf("%s. Usage: /quiet 22:00-07:00", err)
+	}
+	database.SetQuiet(db, chat_id, hours.String())
+	return fmt.Sprintf("Quiet hours set to %s, alerts in them are sent as one message afterwards", hours)
+}
+
+func digestCommand(db *sql.DB, chat_id int64, args string) string {
+	if !database.IsSubscribed(db, chat_id) {
+		return "You are not subscribed, send /start first"
+	}
+	switch args {
+	case "":
+		settings := database.SubscriberSettings(db, chat_id)
+		if settings.Digest == "" {
+			return "No digest. Usage: /digest daily or /digest weekly"
+		}
+		return fmt.Sprintf("Digest: %s, /digest off to stop", settings.Digest)
+	case "off":
+		database.SetDigest(db, chat_id, "")
+		return "Digest stopped"
+	case database.DigestDaily, database.DigestWeekly:
+		database.SetDigest(db, chat_id, args)
+		return fmt.Sprintf("You will get a %s digest of your watched items", args)
+	}
+	return "Usage: /digest daily, /digest weekly or /digest off"
+}
+
+func followUpsCommand(db *sql.DB, chat_id int64, args string) string {
+	if !database.IsSubscribed(db, chat_id) {
+		return "You are not subscribed, send /start first"
+	}
+	switch args {
+	case "":
+		settings := database.SubscriberSettings(db, chat_id)
+		if settings.FollowUps == "" {
+			return "No follow-ups. Usage: /followups message or /followups edit"
+		}
+		return fmt.Sprintf("Follow-ups: %s, /followups off to stop", settings.FollowUps)
+	case "off":
+		database.SetFollowUps(db, chat_id, "")
+		return "Follow-ups stopped"
+	case database.FollowUpMessage:
+		database.SetFollowUps(db, chat_id, args)
+		return "You will get a message when alerted items sell out again"
+	case database.FollowUpEdit:
+		database.SetFollowUps(db, chat_id, args)
+		return "Alerts will be edited to strike through items that sell out again"
+	}
+	return "Usage: /followups message, /followups edit or /followups off"
+}
+
+func inStockCommand(db *sql.DB) string {
+	var rows []database.StockRow
+	for _, r := range database.LatestStock(db) {
+		if r.InStock {
+			rows = append(rows, r)
+		}
+	}
+	if len(rows) == 0 {
+		return "Nothing is in stock"
+	}
+	sortRows(rows)
+
+	msg := "In Stock Items:\n"
+	curr_product := ""
+	for _, r := range rows {
+		if r.ProductName != curr_product {
+			if curr_product != "" {
+				msg += "\n"
+			}
+			curr_product = r.ProductName
+			msg += fmt.Sprintf("%s:\n", curr_product)
+		}
+		msg += fmt.Sprintf("> %s @ %s\n", r.ItemName, r.Price)
+	}
+	return msg
+}
+
+func statusCommand(db *sql.DB, args string) string {
+	if args == "" {
+		return "Usage: /status <item>"
+	}
+	rows := findItems(db, args)
+	if len(rows) == 0 {
+		return fmt.Sprintf("No items matching \"%s\"", args)
+	}
+	msg := ""
+	for _, r := range rows {
+		state := "out of stock"
+		if r.InStock {
+			state = "in stock"
+		}
+		msg += fmt.Sprintf("%s @ %s, %s since %s\n", r.ID(), r.Price, state, r.Timestamp)
+	}
+	return msg
+}
+
+func historyCommand(db *sql.DB, args string) string {
+	if args == "" {
+		return "Usage: /history <item>"
+	}
+	rows := findItems(db, args)
+	switch {
+	case len(rows) == 0:
+		return fmt.Sprintf("No items matching \"%s\"", args)
+	case len(rows) > 1 && rows[0].ID() != args:
+		msg := "Which item?\n"
+		for _, r := range rows {
+			msg += fmt.Sprintf("/history %s\n", r.ID())
+		}
+		return msg
+	}
+	return historyReport(db, rows[0].ID())
+}
+
+// historyReport is an item's stock report, or why it couldn't be made.
+func historyReport(db *sql.DB, id string) string {
+	report, err := analytics.Report(db, id)
+	if err != nil {
+		log.Println(err)
+		return fmt.Sprintf("Couldn't read the history of \"%s\": %s", id, err)
+	}
+	return report
+}
+
+// findItems returns the latest rows of items whose ID contains query, or
+// just the item whose ID is query.
+func findItems(db *sql.DB, query string) []database.StockRow {
+	latest := database.LatestStock(db)
+	if r, ok := latest[query]; ok {
+		return []database.StockRow{r}
+	}
+	var rows []database.StockRow
+	lower := strings.ToLower(query)
+	for id, r := range latest {
+		if strings.Contains(strings.ToLower(id), lower) {
+			rows = append(rows, r)
+		}
+	}
+	sortRows(rows)
+	return rows
+}
+
+func sortRows(rows []database.StockRow) {
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].ID() < rows[j].ID()
+	})
+}
+
+// Truncate cuts msg to at most length bytes, at a line break if it can.
+func Truncate(msg string, length int) string {
+	if len(msg) <= length {
+		return msg
+	}
+	suffix := "\n..."
+	cut := strings.LastIndex(msg[:length-len(suffix)], "\n")
+	if cut < 0 {
+		cut = length - len(suffix)
+	}
+	return msg[:cut] + suffix
+}
@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// Alert keyboards get buttons for at most this many items
+const maxKeyboardItems = 10
+
+const muteFor = 24 * time.Hour
+
+// Callback actions, sent as "<action>:<ref>"
+const (
+	muteAction    = "mute"
+	unwatchAction = "unwatch"
+	historyAction = "history"
+)
+
+// AlertKeyboard returns the buttons for an alert about items: a link to
+// each item's product page, and buttons to mute, unwatch or show the
+// history of the item. Items past maxKeyboardItems get no buttons.
+func AlertKeyboard(db *sql.DB, chat_id int64, items []item.Item) *tgbot.InlineKeyboardMarkup {
+	if len(items) > maxKeyboardItems {
+		items = items[:maxKeyboardItems]
+	}
+	var rows [][]tgbot.InlineKeyboardButton
+	for _, i := range items {
+		if i.Product.URL != "" {
+			text := "Open product page"
+			if len(items) > 1 {
+				text = fmt.Sprintf("Open %s", i.Name)
+			}
+			rows = append(rows, tgbot.NewInlineKeyboardRow(tgbot.NewInlineKeyboardButtonURL(text, i.Product.URL)))
+		}
+		ref := strconv.FormatInt(database.CallbackRef(db, chat_id, i.ID()), 10)
+		rows = append(rows, tgbot.NewInlineKeyboardRow(
+			tgbot.NewInlineKeyboardButtonData("Mute 24h", muteAction+":"+ref),
+			tgbot.NewInlineKeyboardButtonData("Unwatch", unwatchAction+":"+ref),
+			tgbot.NewInlineKeyboardButtonData("Show history", historyAction+":"+ref),
+		))
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	markup := tgbot.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// callbackCommand acts on a button press in a chat, returning a short
+// answer shown over the chat, and a message to send if there is more to
+// say.
+func callbackCommand(db *sql.DB, chat_id int64, data string) (answer string, reply string) {
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return "Unknown button", ""
+	}
+	ref, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "Unknown button", ""
+	}
+	item_id, ok := database.CallbackItem(db, chat_id, ref)
+	if !ok {
+		return "This alert is too old", ""
+	}
+
+	switch parts[0] {
+	case muteAction:
+		database.Mute(db, chat_id, item_id, time.Now().Add(muteFor))
+		return "Muted for 24h", ""
+	case unwatchAction:
+		rule := watch.ExcludeItem(item_id)
+		id := database.AddWatch(db, chat_id, rule)
+		return "Unwatched", fmt.Sprintf("Unwatched %s with #%d: %s\n/unwatch %d to undo", item_id, id, rule, id)
+	case historyAction:
+		return "", historyReport(db, item_id)
+	}
+	return "Unknown button", ""
+}
@@ -1,26 +1,60 @@
 package telegram
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
-func ListenAndServe(api_token string) {
+// ListenAndServe answers bot commands from the allowed chats until ctx is
+// cancelled. Other chats are told their chat id, so it can be allowed.
+func ListenAndServe(ctx context.Context, api_token string, db *sql.DB, allowed map[int64]bool) {
 	bot, err := tgbot.NewBotAPI(api_token)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(allowed) == 0 {
+		log.Println("No chats are allowed to use bot commands, set -chat or -allowed-chats")
+	}
 
 	u := tgbot.NewUpdate(0)
 	u.Timeout = 60
 
 	updates, _ := bot.GetUpdatesChan(u)
-	for update := range updates {
+	for {
+		var update tgbot.Update
+		var ok bool
+		select {
+		case <-ctx.Done():
+			bot.StopReceivingUpdates()
+			return
+		case update, ok = <-updates:
+			if !ok {
+				return
+			}
+		}
+		if update.CallbackQuery != nil {
+			query := update.CallbackQuery
+			if query.Message == nil || !allowed[query.Message.Chat.ID] {
+				if _, err := bot.AnswerCallbackQuery(tgbot.NewCallback(query.ID, "Not allowed")); err != nil {
+					log.Println(err)
+				}
+				continue
+			}
+			answerCallback(bot, db, query)
+			continue
+		}
 		if update.Message == nil {
 			continue
 		}
@@ -28,38 +62,187 @@ func ListenAndServe(api_token string) {
 			continue
 		}
 
-		msg := tgbot.NewMessage(update.Message.Chat.ID, "")
+		chat_id := update.Message.Chat.ID
+		msg := tgbot.NewMessage(chat_id, "")
+		if !allowed[chat_id] {
+			log.Printf("Ignoring /%s from chat %d, it is not allowed", update.Message.Command(), chat_id)
+			msg.Text = fmt.Sprintf("This bot is private. Ask its operator to allow chat %d.", chat_id)
+			if _, err := bot.Send(msg); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		args := strings.TrimSpace(update.Message.CommandArguments())
 		switch update.Message.Command() {
 		case "hi":
 			msg.Text = "Howdy world!"
 		case "latest":
 			msg.Text = readLatestLog()
+		case "help":
+			msg.Text = helpText
+		case "start":
+			msg.Text = startCommand(db, chat_id)
+		case "stop":
+			msg.Text = stopCommand(db, chat_id)
+		case "watch":
+			msg.Text = watchCommand(db, chat_id, args)
+		case "unwatch":
+			msg.Text = unwatchCommand(db, chat_id, args)
+		case "watches":
+			msg.Text = watchesCommand(db, chat_id)
+		case "instock":
+			msg.Text = inStockCommand(db)
+		case "status":
+			msg.Text = statusCommand(db, args)
+		case "history":
+			msg.Text = historyCommand(db, args)
+		case "quiet":
+			msg.Text = quietCommand(db, chat_id, args)
+		case "digest":
+			msg.Text = digestCommand(db, chat_id, args)
+		case "followups":
+			msg.Text = followUpsCommand(db, chat_id, args)
 		default:
-			msg.Text = "I don't know that command"
+			msg.Text = "I don't know that command, try /help"
 		}
+		msg.Text = Truncate(msg.Text, MaxMessageLength)
 
 		if _, err := bot.Send(msg); err != nil {
-			log.Fatal(err)
+			log.Println(err)
 		}
 	}
 }
 
-func SendMessage(api_token, chat_id, msg string) {
-	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", api_token)
+// answerCallback handles a press of an alert's button.
+func answerCallback(bot *tgbot.BotAPI, db *sql.DB, query *tgbot.CallbackQuery) {
+	answer, reply := "", ""
+	if query.Message != nil {
+		chat_id := query.Message.Chat.ID
+		answer, reply = callbackCommand(db, chat_id, query.Data)
+		if reply != "" {
+			if _, err := bot.Send(tgbot.NewMessage(chat_id, Truncate(reply, MaxMessageLength))); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	// Telegram shows a spinner on the button until the query is answered
+	if _, err := bot.AnswerCallbackQuery(tgbot.NewCallback(query.ID, answer)); err != nil {
+		log.Println(err)
+	}
+}
+
+// SendMessage sends a plain text message with an optional inline
+// keyboard, returning its message id.
+func SendMessage(client *http.Client, api_token, chat_id, msg string, keyboard *tgbot.InlineKeyboardMarkup) (int64, error) {
 	data := url.Values{
 		"chat_id": {chat_id},
 		"text":    {msg},
 	}
-	_, err := http.PostForm(endpoint, data)
+	if err := addKeyboard(data, keyboard); err != nil {
+		return 0, err
+	}
+	var result struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := call(client, api_token, "sendMessage", data, &result); err != nil {
+		return 0, err
+	}
+	return result.MessageID, nil
+}
+
+// EditMessageText replaces the text of a sent message with html. The
+// message loses its inline keyboard unless it is passed again.
+func EditMessageText(client *http.Client, api_token, chat_id string, message_id int64, html string, keyboard *tgbot.InlineKeyboardMarkup) error {
+	data := url.Values{
+		"chat_id":    {chat_id},
+		"message_id": {strconv.FormatInt(message_id, 10)},
+		"text":       {html},
+		"parse_mode": {"HTML"},
+	}
+	if err := addKeyboard(data, keyboard); err != nil {
+		return err
+	}
+	return call(client, api_token, "editMessageText", data, nil)
+}
+
+func addKeyboard(data url.Values, keyboard *tgbot.InlineKeyboardMarkup) error {
+	if keyboard == nil {
+		return nil
+	}
+	markup, err := json.Marshal(keyboard)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	data.Set("reply_markup", string(markup))
+	return nil
+}
+
+// Error is a failed bot api call. StatusCode is 0 if there was no
+// response.
+type Error struct {
+	Method      string
+	StatusCode  int
+	Description string
+	// RetryAfter is how long telegram asked to wait before trying again
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram %s: %s", e.Method, e.Description)
+}
+
+// Temporary reports whether the call could succeed if retried
+func (e *Error) Temporary() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// call posts to a bot api method, decoding its result into result if it
+// is not nil. Failures are an *Error.
+func call(client *http.Client, api_token, method string, data url.Values, result interface{}) error {
+	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/%s", api_token, method)
+	resp, err := client.PostForm(endpoint, data)
+	if url_err, ok := err.(*url.Error); ok {
+		// Keep the token, which is part of the url, out of logs
+		return &Error{Method: method, Description: url_err.Err.Error()}
+	} else if err != nil {
+		return &Error{Method: method, Description: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return &Error{Method: method, StatusCode: resp.StatusCode, Description: fmt.Sprintf("%s: %s", resp.Status, err)}
+	}
+	if !body.OK {
+		return &Error{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			Description: body.Description,
+			RetryAfter:  time.Duration(body.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	if result != nil {
+		if err := json.Unmarshal(body.Result, result); err != nil {
+			return &Error{Method: method, StatusCode: resp.StatusCode, Description: err.Error()}
+		}
 	}
+	return nil
 }
 
 func readLatestLog() string {
 	content, err := ioutil.ReadFile("latest_log.txt")
-	if err != nil {
-		log.Fatal(err)
+	if os.IsNotExist(err) {
+		return "There is no latest log yet"
+	} else if err != nil {
+		log.Println(err)
+		return fmt.Sprintf("Couldn't read the latest log: %s", err)
 	}
 	return string(content)
 }
@@ -0,0 +1,25 @@
+package vendors
+
+import (
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+)
+
+// MagentoPrice reads the price markup shared by Magento storefronts like
+// Rogue and Rep: special/old prices on sales and from/to price ranges.
+// Prices that cannot be parsed are left unset.
+func MagentoPrice(selection *goquery.Selection) (price, max, was money.Money) {
+	if special := selection.Find(".special-price .price").First(); special.Length() > 0 {
+		price, _ = money.Parse(special.Text())
+		was, _ = money.Parse(selection.Find(".old-price .price").First().Text())
+		return price, max, was
+	}
+	if from := selection.Find(".price-from .price").First(); from.Length() > 0 {
+		price, _ = money.Parse(from.Text())
+		max, _ = money.Parse(selection.Find(".price-to .price").First().Text())
+		return price, max, was
+	}
+	price, max, _ = money.ParseRange(selection.Find(".price").First().Text())
+	return price, max, was
+}
@@ -1,15 +1,31 @@
 package rep
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 
 	"github.com/maxtrussell/gym-stock-bot/models/item"
 	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/vendors"
 )
 
-func MakeRep(doc *goquery.Document, product product.Product) []item.Item {
+type Rep struct{}
+
+func init() {
+	vendors.Register(Rep{})
+}
+
+func (Rep) Name() string {
+	return "RepFitness"
+}
+
+func (Rep) Categories() []string {
+	return []string{"multi", "single", "rack"}
+}
+
+func (Rep) Parse(doc *goquery.Document, product product.Product) ([]item.Item, error) {
 	var items []item.Item
 	switch product.Category {
 	case "multi":
@@ -18,8 +34,10 @@ func MakeRep(doc *goquery.Document, product product.Product) []item.Item {
 		items = makeRepSingle(doc, product)
 	case "rack":
 		items = makeRepRack(doc, product)
+	default:
+		return nil, fmt.Errorf("rep: unsupported category %q", product.Category)
 	}
-	return items
+	return items, nil
 }
 
 func makeRepMulti(doc *goquery.Document, product product.Product) []item.Item {
@@ -28,9 +46,9 @@ func makeRepMulti(doc *goquery.Document, product product.Product) []item.Item {
 		i := item.Item{
 			Product:      &product,
 			Name:         selection.Find(".product-item-name").Text(),
-			Price:        selection.Find(".price").Text(),
 			Availability: strings.Trim(selection.Find(".qty-container").Text(), " \n"),
 		}
+		i.Price, i.MaxPrice, i.WasPrice = vendors.MagentoPrice(selection)
 		if i.Availability == "" {
 			i.Availability = doc.Find(".product-info-stock-sku span").Text()
 		}
@@ -47,9 +65,9 @@ func makeRepSingle(doc *goquery.Document, product product.Product) []item.Item {
 	i := item.Item{
 		Product:      &product,
 		Name:         product.Name, // temporary
-		Price:        doc.Find(".price").Text(),
 		Availability: doc.Find(".product-info-stock-sku span").Text(),
 	}
+	i.Price, i.MaxPrice, i.WasPrice = vendors.MagentoPrice(doc.Selection)
 	items = append(items, i)
 	return items
 }
@@ -60,9 +78,10 @@ func makeRepRack(doc *goquery.Document, product product.Product) []item.Item {
 	i := item.Item{
 		Product:      &product,
 		Name:         product.Name, // temporary
-		Price:        doc.Find(".price-to .price").Text(),
 		Availability: doc.Find(".product-info-stock-sku span").Text(),
 	}
+	// Racks list a from/to range across their configurations
+	i.Price, i.MaxPrice, i.WasPrice = vendors.MagentoPrice(doc.Selection)
 	items = append(items, i)
 	return items
 }
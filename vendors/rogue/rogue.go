@@ -2,26 +2,45 @@ package rogue
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 
 	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
 	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/vendors"
 )
 
-func MakeRogue(doc *goquery.Document, product product.Product) []item.Item {
+type Rogue struct{}
+
+func init() {
+	vendors.Register(Rogue{})
+}
+
+func (Rogue) Name() string {
+	return "Rogue"
+}
+
+func (Rogue) Categories() []string {
+	return []string{"multi", "single", "script"}
+}
+
+func (Rogue) Parse(doc *goquery.Document, product product.Product) ([]item.Item, error) {
 	var items []item.Item
+	var err error
 	switch product.Category {
 	case "multi":
 		items = makeRogueMulti(doc, product)
 	case "single":
 		items = makeRogueSingle(doc, product)
 	case "script":
-		items = makeFromScript(doc, product, "RogueColorSwatches")
+		items, err = makeFromScript(doc, product, "RogueColorSwatches")
+	default:
+		return nil, fmt.Errorf("rogue: unsupported category %q", product.Category)
 	}
-	return items
+	return items, err
 }
 
 func makeRogueSingle(doc *goquery.Document, product product.Product) []item.Item {
@@ -29,9 +48,9 @@ func makeRogueSingle(doc *goquery.Document, product product.Product) []item.Item
 	i := item.Item{
 		Product:      &product,
 		Name:         doc.Find(".product-title").Text(),
-		Price:        doc.Find(".price").Text(),
 		Availability: strings.Trim(doc.Find(".product-options-bottom button").Text(), " \n"),
 	}
+	i.Price, i.MaxPrice, i.WasPrice = vendors.MagentoPrice(doc.Selection)
 	if strings.Contains(i.Availability, "Notify Me") {
 		i.Availability = "Out of stock"
 	}
@@ -45,15 +64,15 @@ func makeRogueMulti(doc *goquery.Document, product product.Product) []item.Item
 		i := item.Item{
 			Product:      &product,
 			Name:         strings.TrimSpace(selection.Find(".item-name").Text()),
-			Price:        selection.Find(".price").Text(),
 			Availability: strings.Trim(selection.Find(".bin-stock-availability").Text(), " \n"),
 		}
+		i.Price, i.MaxPrice, i.WasPrice = vendors.MagentoPrice(selection)
 		items = append(items, i)
 	})
 	return items
 }
 
-func makeFromScript(doc *goquery.Document, product product.Product, script_name string) []item.Item {
+func makeFromScript(doc *goquery.Document, product product.Product, script_name string) ([]item.Item, error) {
 	var items []item.Item
 	// Find json blob to parse
 	selection := doc.Find("script[type='text/javascript']")
@@ -66,34 +85,42 @@ func makeFromScript(doc *goquery.Document, product product.Product, script_name
 			for _, line := range strings.Split(c.Data, "\n") {
 				stripped_line := strings.Trim(line, " ")
 				if strings.HasPrefix(stripped_line, "{") {
-					items = append(
-						items,
-						parseColorSwatchJson(stripped_line[:len(stripped_line)-1], product)...,
-					)
+					line_items, err := parseColorSwatchJson(stripped_line[:len(stripped_line)-1], product)
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, line_items...)
 				}
 			}
 		}
 	}
-	return items
+	return items, nil
 }
 
 // helper function for makeFromScript
-func parseColorSwatchJson(color_swatch string, product product.Product) []item.Item {
+func parseColorSwatchJson(color_swatch string, product product.Product) ([]item.Item, error) {
 	var top_level map[string]interface{}
 	err := json.Unmarshal([]byte(color_swatch), &top_level)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("rogue: bad color swatch json: %s", err)
 	}
 
 	// 1. Get options
 	var item_options []map[string]interface{}
-	attributes := top_level["attributes"].(map[string]interface{})
+	attributes, ok := top_level["attributes"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rogue: color swatch json has no attributes")
+	}
 	for _, val := range attributes {
-		options, _ := val.(map[string]interface{})["options"]
-		for _, option := range options.([]interface{}) {
-			if additional_option, ok := option.(map[string]interface{})["additional_options"]; ok {
-				for _, item_info := range additional_option.(map[string]interface{}) {
-					item_options = append(item_options, item_info.(map[string]interface{}))
+		attribute, _ := val.(map[string]interface{})
+		options, _ := attribute["options"].([]interface{})
+		for _, option := range options {
+			option_map, _ := option.(map[string]interface{})
+			if additional_option, ok := option_map["additional_options"].(map[string]interface{}); ok {
+				for _, item_info := range additional_option {
+					if info, ok := item_info.(map[string]interface{}); ok {
+						item_options = append(item_options, info)
+					}
 				}
 			}
 		}
@@ -102,20 +129,28 @@ func parseColorSwatchJson(color_swatch string, product product.Product) []item.I
 	// 2. Convert options to items
 	var items []item.Item
 	for _, option := range item_options {
+		in_stock, ok := option["isInStock"].(bool)
+		label, label_ok := option["realLabel"].(string)
+		if !ok || !label_ok || len(label) < 3 {
+			return nil, fmt.Errorf("rogue: unexpected color swatch option %v", option)
+		}
 		var availability string
-		if option["isInStock"].(bool) {
+		if in_stock {
 			availability = "In stock"
 		} else {
 			availability = "Out of stock"
 		}
+		// bin_price looks like "275.0000"
+		bin_price, _ := option["bin_price"].(string)
+		price, _ := money.Parse(bin_price)
 		i := item.Item{
 			Product:      &product,
-			Name:         option["realLabel"].(string)[3:],
-			Price:        "$" + strings.TrimSuffix(option["bin_price"].(string), "00"),
+			Name:         label[3:],
+			Price:        price,
 			Availability: availability,
 		}
 		items = append(items, i)
 	}
 
-	return items
+	return items, nil
 }
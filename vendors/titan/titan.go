@@ -1,17 +1,153 @@
 package titan
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
 	"github.com/PuerkitoBio/goquery"
 
 	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
 	"github.com/maxtrussell/gym-stock-bot/models/product"
+	"github.com/maxtrussell/gym-stock-bot/vendors"
 )
 
-func MakeTitan(doc *goquery.Document, product product.Product) []item.Item {
+type Titan struct{}
+
+func init() {
+	vendors.Register(Titan{})
+}
+
+func (Titan) Name() string {
+	return "Titan"
+}
+
+func (Titan) Categories() []string {
+	return []string{"multi", "single", "rack"}
+}
+
+func (Titan) Parse(doc *goquery.Document, product product.Product) ([]item.Item, error) {
 	var items []item.Item
+	var err error
 	switch product.Category {
+	case "multi":
+		items, err = makeTitanMulti(doc, product)
+	case "single":
+		items, err = makeTitanSingle(doc, product)
 	case "rack":
-		items = makeTitanRack(doc, product)
+		items, err = makeTitanRack(doc, product)
+	default:
+		return nil, fmt.Errorf("titan: unsupported category %q", product.Category)
+	}
+	return items, err
+}
+
+// shopifyProduct is the subset of Shopify's product JSON that Titan
+// embeds in a ProductJson script tag.
+type shopifyProduct struct {
+	Title    string           `json:"title"`
+	Variants []shopifyVariant `json:"variants"`
+}
+
+// Shopify prices are in cents
+type shopifyVariant struct {
+	Title          string `json:"title"`
+	Price          int64  `json:"price"`
+	CompareAtPrice int64  `json:"compare_at_price"`
+	Available      bool   `json:"available"`
+}
+
+func makeTitanMulti(doc *goquery.Document, product product.Product) ([]item.Item, error) {
+	p, err := findProductJson(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("titan: no product json on page")
+	}
+	var items []item.Item
+	for _, v := range p.Variants {
+		items = append(items, variantItem(v, v.Title, product))
+	}
+	return items, nil
+}
+
+func makeTitanRack(doc *goquery.Document, product product.Product) ([]item.Item, error) {
+	// Racks are sold as a single variant or as height/depth combinations,
+	// and a lone variant is titled "Default Title" by Shopify.
+	p, err := findProductJson(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return makeTitanSingle(doc, product)
+	}
+	var items []item.Item
+	for _, v := range p.Variants {
+		name := v.Title
+		if len(p.Variants) == 1 || name == "Default Title" {
+			name = product.Name
+		}
+		items = append(items, variantItem(v, name, product))
+	}
+	return items, nil
+}
+
+func makeTitanSingle(doc *goquery.Document, product product.Product) ([]item.Item, error) {
+	p, err := findProductJson(doc)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil && len(p.Variants) > 0 {
+		return []item.Item{variantItem(p.Variants[0], product.Name, product)}, nil
+	}
+
+	// Fall back to the rendered page when there is no variant json
+	availability := "Out of stock"
+	button := doc.Find("#AddToCart, .product-form__cart-submit").First()
+	_, disabled := button.Attr("disabled")
+	if button.Length() > 0 && !disabled && !strings.Contains(strings.ToLower(button.Text()), "sold out") {
+		availability = "In stock"
+	}
+	i := item.Item{
+		Product:      &product,
+		Name:         product.Name,
+		Availability: availability,
+	}
+	i.Price, i.MaxPrice, _ = money.ParseRange(doc.Find(".product__price, [itemprop='price']").First().Text())
+	if was := doc.Find(".product__price--compare").First(); was.Length() > 0 {
+		i.WasPrice, _ = money.Parse(was.Text())
+	}
+	return []item.Item{i}, nil
+}
+
+func variantItem(v shopifyVariant, name string, product product.Product) item.Item {
+	availability := "Out of stock"
+	if v.Available {
+		availability = "In stock"
+	}
+	i := item.Item{
+		Product:      &product,
+		Name:         strings.TrimSpace(name),
+		Price:        money.USD(v.Price),
+		Availability: availability,
+	}
+	if v.CompareAtPrice > v.Price {
+		i.WasPrice = money.USD(v.CompareAtPrice)
+	}
+	return i
+}
+
+// findProductJson returns nil, nil if the page has no product json
+func findProductJson(doc *goquery.Document) (*shopifyProduct, error) {
+	selection := doc.Find("script[id^='ProductJson'], script[data-product-json]").First()
+	if selection.Length() == 0 {
+		return nil, nil
+	}
+	var p shopifyProduct
+	if err := json.Unmarshal([]byte(selection.Text()), &p); err != nil {
+		return nil, fmt.Errorf("titan: bad product json: %s", err)
 	}
-	return items
+	return &p, nil
 }
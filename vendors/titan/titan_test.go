@@ -0,0 +1,110 @@
+package titan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+type want struct {
+	name      string
+	price     int64
+	was       int64
+	available bool
+}
+
+// The fixtures in test_pages are hand-written stand-ins for Titan's
+// product pages, not captured ones. Replace them with -update-test-files
+// and update the wants below to match the captured pages.
+var fixtures = []struct {
+	product product.Product
+	items   []want
+}{
+	{
+		product.Product{
+			Name:     "Titan T-3 Power Rack",
+			Brand:    "Titan",
+			Category: "rack",
+			URL:      "https://www.titan.fitness/products/t-3-series-power-rack",
+		},
+		[]want{
+			{`71" Height / 24" Depth`, 37999, 0, false},
+			{`71" Height / 36" Depth`, 41999, 0, true},
+			{`83" Height / 24" Depth`, 39999, 0, false},
+			{`83" Height / 36" Depth`, 43999, 0, false},
+		},
+	},
+	{
+		product.Product{
+			Name:     "Titan Cast Iron Olympic Plates",
+			Brand:    "Titan",
+			Category: "multi",
+			URL:      "https://www.titan.fitness/products/cast-iron-olympic-plates",
+		},
+		[]want{
+			{"2.5 LB Pair", 2499, 0, true},
+			{"5 LB Pair", 3499, 0, true},
+			{"10 LB Pair", 5499, 0, false},
+			{"25 LB Pair", 10999, 12999, false},
+			{"35 LB Pair", 14999, 0, false},
+			{"45 LB Pair", 18999, 0, true},
+		},
+	},
+	{
+		product.Product{
+			Name:     "Titan Olympic Barbell 45LB",
+			Brand:    "Titan",
+			Category: "single",
+			URL:      "https://www.titan.fitness/products/olympic-barbell-45lb",
+		},
+		[]want{
+			{"Titan Olympic Barbell 45LB", 14999, 0, false},
+		},
+	},
+}
+
+func TestParseFixtures(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.product.Name, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("..", "..", f.product.GetTestFile()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			doc, err := goquery.NewDocumentFromReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			items, err := Titan{}.Parse(doc, f.product)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != len(f.items) {
+				t.Fatalf("got %d items, want %d", len(items), len(f.items))
+			}
+			for n, i := range items {
+				w := f.items[n]
+				if i.Name != w.name {
+					t.Errorf("item %d: name %q, want %q", n, i.Name, w.name)
+				}
+				if i.Price != money.USD(w.price) {
+					t.Errorf("%s: price %s, want %s", w.name, i.Price, money.USD(w.price))
+				}
+				if w.was != 0 && i.WasPrice != money.USD(w.was) {
+					t.Errorf("%s: was price %s, want %s", w.name, i.WasPrice, money.USD(w.was))
+				} else if w.was == 0 && i.WasPrice.Valid() {
+					t.Errorf("%s: unexpected was price %s", w.name, i.WasPrice)
+				}
+				if i.IsAvailable() != w.available {
+					t.Errorf("%s: available %t, want %t", w.name, i.IsAvailable(), w.available)
+				}
+			}
+		})
+	}
+}
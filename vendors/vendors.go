@@ -0,0 +1,88 @@
+package vendors
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+// Vendor knows how to turn a retailer's product page into items.
+// Implementations register themselves from an init function.
+type Vendor interface {
+	Name() string
+	Categories() []string
+	Parse(doc *goquery.Document, product product.Product) ([]item.Item, error)
+}
+
+var registry = map[string]Vendor{}
+
+func Register(v Vendor) {
+	if _, ok := registry[v.Name()]; ok {
+		panic(fmt.Sprintf("vendor %q registered twice", v.Name()))
+	}
+	registry[v.Name()] = v
+}
+
+func Get(brand string) (Vendor, error) {
+	v, ok := registry[brand]
+	if !ok {
+		return nil, fmt.Errorf("unknown brand %q (known: %s)", brand, strings.Join(Names(), ", "))
+	}
+	return v, nil
+}
+
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that a product's brand and category are handled by a
+// registered vendor.
+func Validate(p product.Product) error {
+	v, err := Get(p.Brand)
+	if err != nil {
+		return fmt.Errorf("product %q: %s", p.Name, err)
+	}
+	for _, c := range v.Categories() {
+		if c == p.Category {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"product %q: unknown category %q for brand %q (supported: %s)",
+		p.Name,
+		p.Category,
+		p.Brand,
+		strings.Join(v.Categories(), ", "),
+	)
+}
+
+func ValidateAll(products []product.Product) error {
+	var msgs []string
+	for _, p := range products {
+		if err := Validate(p); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("invalid products:\n%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+func MakeItems(doc *goquery.Document, p product.Product) ([]item.Item, error) {
+	if err := Validate(p); err != nil {
+		return nil, err
+	}
+	v, _ := Get(p.Brand)
+	return v.Parse(doc, p)
+}
@@ -0,0 +1,521 @@
+// Package watch parses and evaluates watch rules. Each rule is one line of
+// space separated matchers, all of which must match an item:
+//
+//	brand:Rogue product:"Ohio Power Bar" max:$350
+//	product:"Bumper Plates" weight:10-45 -brand:RepFitness
+//	id:/York Legacy.* (2\.5|5|10)LB/
+//	tag:bar under:$300 drop:10% priority:high
+//	exclude item:Pair
+//
+// Matchers are brand, product, item (the item name), id (the full item
+// ID) and tag, which match case-insensitively as substrings or as a
+// regex when written /like this/, plus weight (pounds, or kg with a kg
+// suffix) and max (price). A leading "-" negates a matcher. under and
+// drop add price alerts, and priority (1-5, or min, low, default, high
+// and urgent) sets how loudly push notifiers alert. Rules starting with
+// "exclude" stop items from matching any other rule.
+//
+// Rules live in the db's watches table. Load reads the older watched.txt
+// format, one rule per line, where blank lines and lines starting with #
+// are ignored. Lines from before rules, a regex matched against item IDs
+// optionally followed by " :: under $300, drop 10%", are converted to
+// rules like id:/regex/ under:$300 drop:10%.
+package watch
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+)
+
+type Rule struct {
+	Text    string
+	Exclude bool
+
+	matchers []matcher
+
+	// Under alerts when the price falls below this
+	Under       money.Money
+	DropPercent float64
+	DropAmount  money.Money
+
+	// Priority is from 1 to 5, or 0 if unset
+	Priority int
+}
+
+type Rules []Rule
+
+// Alert priorities, as used by ntfy
+const (
+	MinPriority     = 1
+	DefaultPriority = 3
+	MaxPriority     = 5
+)
+
+var priority_names = map[string]int{
+	"min":     1,
+	"low":     2,
+	"default": 3,
+	"high":    4,
+	"urgent":  5,
+}
+
+type PriceChange struct {
+	Item     item.Item
+	Previous money.Money
+	Reason   string
+}
+
+type matcher struct {
+	field  string
+	negate bool
+
+	text       string
+	re         *regexp.Regexp
+	min_weight float64
+	max_weight float64
+	price      money.Money
+}
+
+var text_fields = map[string]bool{
+	"brand":   true,
+	"product": true,
+	"item":    true,
+	"id":      true,
+	"tag":     true,
+}
+
+// other_fields are the other keys a rule's tokens can have
+var other_fields = map[string]bool{
+	"weight":   true,
+	"max":      true,
+	"under":    true,
+	"drop":     true,
+	"priority": true,
+}
+
+func (r Rule) String() string {
+	return r.Text
+}
+
+func (r Rule) Matches(i item.Item) bool {
+	for _, m := range r.matchers {
+		if m.matches(i) == m.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Rule) HasPriceTrigger() bool {
+	return r.Under.Valid() || r.DropPercent > 0 || r.DropAmount.Valid()
+}
+
+// PriceTrigger returns why a move from prev to curr should alert, or ""
+// if it should not. prev may be invalid for items seen the first time.
+func (r Rule) PriceTrigger(prev, curr money.Money) string {
+	if !curr.Valid() {
+		return ""
+	}
+	if r.Under.Valid() && curr.Less(r.Under) && (!prev.Valid() || !prev.Less(r.Under)) {
+		return fmt.Sprintf("under %s", r.Under)
+	}
+	if !curr.Less(prev) {
+		return ""
+	}
+	drop := prev.Cents - curr.Cents
+	if r.DropAmount.Valid() && drop >= r.DropAmount.Cents {
+		return fmt.Sprintf("dropped %s", money.Money{Cents: drop, Currency: curr.Currency})
+	}
+	if r.DropPercent > 0 && float64(drop)*100 >= r.DropPercent*float64(prev.Cents) {
+		return fmt.Sprintf("dropped %.0f%%", float64(drop)*100/float64(prev.Cents))
+	}
+	return ""
+}
+
+func (m matcher) matches(i item.Item) bool {
+	switch m.field {
+	case "weight":
+		w, ok := i.Weight()
+		// Allow for rounding in kg conversions
+		return ok && w >= m.min_weight-0.01 && w <= m.max_weight+0.01
+	case "max":
+		return i.Price.Valid() && !m.price.Less(i.Price)
+	case "tag":
+		for _, t := range i.Product.Tags {
+			if m.matchText(t) {
+				return true
+			}
+		}
+		return false
+	case "brand":
+		return m.matchText(i.Product.Brand)
+	case "product":
+		return m.matchText(i.Product.Name)
+	case "item":
+		return m.matchText(i.Name)
+	}
+	return m.matchText(i.ID())
+}
+
+func (m matcher) matchText(s string) bool {
+	if m.re != nil {
+		return m.re.MatchString(s)
+	}
+	return strings.Contains(strings.ToLower(s), m.text)
+}
+
+// Watched reports whether any rule matches i, and no exclusion does.
+func (rules Rules) Watched(i item.Item) bool {
+	return len(rules.Match(i)) > 0
+}
+
+// Match returns the non-exclusion rules matching i, or none if i is
+// excluded.
+func (rules Rules) Match(i item.Item) []Rule {
+	var matched []Rule
+	for _, r := range rules {
+		if !r.Matches(i) {
+			continue
+		}
+		if r.Exclude {
+			return nil
+		}
+		matched = append(matched, r)
+	}
+	return matched
+}
+
+// Priority returns the highest priority of the rules matching i, or the
+// default priority if none set one.
+func (rules Rules) Priority(i item.Item) int {
+	priority := 0
+	for _, r := range rules.Match(i) {
+		if r.Priority > priority {
+			priority = r.Priority
+		}
+	}
+	if priority == 0 {
+		return DefaultPriority
+	}
+	return priority
+}
+
+// PriceChanges returns the items whose move from their previous price
+// trips a price trigger of a matching rule.
+func (rules Rules) PriceChanges(items []item.Item, previous map[string]money.Money) []PriceChange {
+	var changes []PriceChange
+	for _, i := range items {
+		prev := previous[i.ID()]
+		for _, r := range rules.Match(i) {
+			if !r.HasPriceTrigger() {
+				continue
+			}
+			if reason := r.PriceTrigger(prev, i.Price); reason != "" {
+				changes = append(changes, PriceChange{Item: i, Previous: prev, Reason: reason})
+				break
+			}
+		}
+	}
+	return changes
+}
+
+func Parse(line string) (Rule, error) {
+	r := Rule{Text: strings.TrimSpace(line)}
+	tokens, err := tokenize(r.Text)
+	if err != nil {
+		return r, err
+	}
+	if len(tokens) > 0 && tokens[0] == "exclude" {
+		r.Exclude = true
+		tokens = tokens[1:]
+	}
+
+	for _, token := range tokens {
+		m := matcher{}
+		if strings.HasPrefix(token, "-") {
+			m.negate = true
+			token = token[1:]
+		}
+		kv := strings.SplitN(token, ":", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return r, fmt.Errorf("bad matcher %q, want key:value", token)
+		}
+		m.field = kv[0]
+		value := unquote(kv[1])
+
+		switch {
+		case text_fields[m.field]:
+			if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+				m.re, err = regexp.Compile(value[1 : len(value)-1])
+			} else {
+				m.text = strings.ToLower(value)
+			}
+		case m.field == "weight":
+			m.min_weight, m.max_weight, err = parseWeight(value)
+		case m.field == "max":
+			m.price, err = money.Parse(value)
+		case m.field == "priority":
+			if m.negate || r.Exclude {
+				return r, fmt.Errorf("priority cannot be negated or used in an exclusion")
+			}
+			if r.Priority, err = parsePriority(value); err != nil {
+				return r, fmt.Errorf("bad priority %q: %s", value, err)
+			}
+			continue
+		case m.field == "under" || m.field == "drop":
+			if m.negate || r.Exclude {
+				return r, fmt.Errorf("%s cannot be negated or used in an exclusion", m.field)
+			}
+			err = r.parseTrigger(m.field, value)
+			if err != nil {
+				return r, fmt.Errorf("bad %s %q: %s", m.field, value, err)
+			}
+			continue
+		default:
+			return r, fmt.Errorf("unknown matcher %q", m.field)
+		}
+		if err != nil {
+			return r, fmt.Errorf("bad %s %q: %s", m.field, value, err)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+
+	if len(r.matchers) == 0 {
+		return r, fmt.Errorf("rule has no matchers")
+	}
+	return r, nil
+}
+
+// ExcludeItem returns a rule excluding exactly the item with an ID.
+func ExcludeItem(id string) string {
+	escaped := strings.Replace(regexp.QuoteMeta(id), "/", `\/`, -1)
+	return fmt.Sprintf("exclude id:/^%s$/", escaped)
+}
+
+func (r *Rule) parseTrigger(field, value string) error {
+	var err error
+	switch {
+	case field == "under":
+		r.Under, err = money.Parse(value)
+	case strings.HasSuffix(value, "%"):
+		r.DropPercent, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err == nil && (r.DropPercent <= 0 || r.DropPercent >= 100) {
+			err = fmt.Errorf("percent must be between 0 and 100")
+		}
+	default:
+		r.DropAmount, err = money.Parse(value)
+	}
+	return err
+}
+
+func parsePriority(value string) (int, error) {
+	if p, ok := priority_names[strings.ToLower(value)]; ok {
+		return p, nil
+	}
+	p, err := strconv.Atoi(value)
+	if err != nil || p < MinPriority || p > MaxPriority {
+		return 0, fmt.Errorf("want 1-5 or min, low, default, high or urgent")
+	}
+	return p, nil
+}
+
+// parseWeight reads "45", "10-45", "10-", "-45" or "20-25kg" into a range
+// in pounds.
+func parseWeight(s string) (float64, float64, error) {
+	scale := 1.0
+	lower := strings.ToLower(s)
+	if strings.HasSuffix(lower, "kg") {
+		scale = 2.20462
+		s = s[:len(s)-2]
+	} else if strings.HasSuffix(lower, "lb") {
+		s = s[:len(s)-2]
+	}
+
+	if s == "" || s == "-" {
+		return 0, 0, fmt.Errorf("want a weight or range")
+	}
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) == 1 {
+		bounds = append(bounds, bounds[0])
+	}
+	min, max := 0.0, 1e9
+	var err error
+	if bounds[0] != "" {
+		if min, err = strconv.ParseFloat(bounds[0], 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if bounds[1] != "" {
+		if max, err = strconv.ParseFloat(bounds[1], 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("empty range")
+	}
+	return min * scale, max * scale, nil
+}
+
+// tokenize splits a rule on spaces, keeping "quoted values" and
+// /regex values/ whole. A backslash escapes the closing quote or slash.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	var closing rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case closing != 0:
+			token.WriteRune(c)
+			if c == closing && !escaped {
+				closing = 0
+			}
+			escaped = c == '\\' && !escaped
+		case c == ' ' || c == '\t':
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		case (c == '"' || c == '/') && strings.HasSuffix(token.String(), ":"):
+			closing = c
+			token.WriteRune(c)
+		default:
+			token.WriteRune(c)
+		}
+	}
+	if closing != 0 {
+		return nil, fmt.Errorf("unterminated %c", closing)
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+// unquote strips the quotes of a "quoted value", undoing its escapes
+func unquote(s string) string {
+	if len(s) > 1 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+// LoadStored reads a chat's rules from the db's watches table.
+func LoadStored(db *sql.DB, chat_id int64) (Rules, error) {
+	rules := Rules{}
+	var msgs []string
+	for _, w := range database.Watches(db, chat_id) {
+		r, err := Parse(w.Rule)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("watch #%d: %s", w.ID, err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid watch rules:\n%s", strings.Join(msgs, "\n"))
+	}
+	return rules, nil
+}
+
+// Load reads rules from a file, one per line, reporting every bad line.
+// A missing file has no rules.
+func Load(path string) (Rules, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Rules{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseLines(path, lines)
+}
+
+func ParseLines(source string, lines []string) (Rules, error) {
+	rules := Rules{}
+	var msgs []string
+	for n, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if isOldFormat(trimmed) {
+			converted, err := convertOld(trimmed)
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("%s:%d: %s", source, n+1, err))
+				continue
+			}
+			trimmed = converted
+		}
+		r, err := Parse(trimmed)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s:%d: %s", source, n+1, err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid watch rules:\n%s", strings.Join(msgs, "\n"))
+	}
+	return rules, nil
+}
+
+// isOldFormat reports whether a watched.txt line is from before rules,
+// as its first token has no known key.
+func isOldFormat(line string) bool {
+	first := strings.Fields(line)[0]
+	if first == "exclude" {
+		return false
+	}
+	kv := strings.SplitN(strings.TrimPrefix(first, "-"), ":", 2)
+	return len(kv) != 2 || !(text_fields[kv[0]] || other_fields[kv[0]])
+}
+
+// convertOld rewrites an old watched.txt line as a rule.
+func convertOld(line string) (string, error) {
+	parts := strings.SplitN(line, " :: ", 2)
+	rule := "id:/" + escapeSlashes(strings.TrimSpace(parts[0])) + "/"
+	if len(parts) == 2 {
+		for _, trigger := range strings.Split(parts[1], ",") {
+			fields := strings.Fields(trigger)
+			if len(fields) != 2 {
+				return "", fmt.Errorf("bad price trigger %q", trigger)
+			}
+			rule += fmt.Sprintf(" %s:%s", fields[0], fields[1])
+		}
+	}
+	return rule, nil
+}
+
+// escapeSlashes escapes the slashes of a regex not already escaped, so it
+// can be written /like this/.
+func escapeSlashes(re string) string {
+	var b strings.Builder
+	escaped := false
+	for _, c := range re {
+		if c == '/' && !escaped {
+			b.WriteRune('\\')
+		}
+		escaped = c == '\\' && !escaped
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,331 @@
+package watch
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+var (
+	rack    = &product.Product{Name: "Titan T-3 Power Rack", Brand: "Titan", Tags: []string{"rack"}}
+	plates  = &product.Product{Name: "Rogue Olympic Plates", Brand: "Rogue", Tags: []string{"plates"}}
+	bumpers = &product.Product{Name: "Rep Bumper Plates", Brand: "RepFitness", Tags: []string{"plates"}}
+	bar     = &product.Product{Name: "Rogue Ohio Power Bar", Brand: "Rogue", Tags: []string{"bar"}}
+	york    = &product.Product{Name: "York Legacy Olympic Plates", Brand: "York"}
+
+	rack_item    = item.Item{Product: rack, Name: `71" Height / 36" Depth`, Price: money.USD(41999)}
+	combo_item   = item.Item{Product: rack, Name: "Bar/Rack Combo", Price: money.USD(59999)}
+	tree_item    = item.Item{Product: rack, Name: `Plate Tree 2"`, Price: money.USD(9999)}
+	plate_45     = item.Item{Product: plates, Name: "45LB Pair", Price: money.USD(18999)}
+	plate_125    = item.Item{Product: plates, Name: "1.25LB Pair", Price: money.USD(2999)}
+	bumper_20kg  = item.Item{Product: bumpers, Name: "20KG Bumper Plate", Price: money.USD(15000)}
+	bar_item     = item.Item{Product: bar, Name: `Ohio Power Bar 45LB \ Stainless`, Price: money.USD(32500)}
+	york_10      = item.Item{Product: york, Name: "10LB"}
+	york_25      = item.Item{Product: york, Name: "25LB"}
+	unknown_item = item.Item{Product: plates, Name: "Mystery Pair"}
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  string
+	}{
+		{"", "rule has no matchers"},
+		{"brand", "want key:value"},
+		{"brand:", "want key:value"},
+		{"color:red", "unknown matcher"},
+		{`product:"Ohio Power`, "unterminated \""},
+		{"item:/^45LB", "unterminated /"},
+		{`item:/\/`, "unterminated /"},
+		{"item:/(/", "bad item"},
+		{"weight:45-10", "bad weight"},
+		{"weight:heavy", "bad weight"},
+		{"max:free", "bad max"},
+		{"brand:Rogue priority:9", "bad priority"},
+		{"brand:Rogue -priority:high", "cannot be negated"},
+		{"priority:high", "rule has no matchers"},
+		{"brand:Rogue -under:$300", "cannot be negated"},
+		{"exclude brand:Rogue under:$300", "cannot be negated or used in an exclusion"},
+		{"brand:Rogue drop:150%", "bad drop"},
+		{"brand:Rogue drop:0%", "bad drop"},
+		{"brand:Rogue drop:$-5", "bad drop"},
+		{"brand:Rogue under:cheap", "bad under"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.rule)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Parse(%q): %v, want an error with %q", test.rule, err, test.err)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		rule string
+		item item.Item
+		want bool
+	}{
+		// Text matchers are case-insensitive substrings
+		{"brand:rogue", bar_item, true},
+		{"brand:rogue", rack_item, false},
+		{"product:ohio", bar_item, true},
+		{`product:"Ohio Power Bar"`, bar_item, true},
+		{`product:"Ohio  Power Bar"`, bar_item, false},
+		{"item:pair", plate_45, true},
+		{"id:/^Rogue Olympic Plates: 45LB/", plate_45, true},
+		{"tag:bar", bar_item, true},
+		{"tag:bar", rack_item, false},
+		{"tag:/^plate/", bumper_20kg, true},
+		// Regexes are case-sensitive, and the whole of a regex value
+		// is kept with its spaces
+		{`item:/^1\.25LB/`, plate_125, true},
+		{`item:/^1\.25LB/`, plate_45, false},
+		{"item:/pair/", plate_45, false},
+		{`id:/Plates: (1\.25|45)LB Pair$/`, plate_125, true},
+		// Escapes
+		{`item:"Plate Tree 2\""`, tree_item, true},
+		{`item:/Bar\/Rack/`, combo_item, true},
+		{`item:/\\/`, bar_item, true},
+		{`item:/\\/`, plate_45, false},
+		{`item:"\\ Stainless"`, bar_item, true},
+		// Every matcher must match, and - negates one
+		{"brand:Rogue item:Pair", plate_45, true},
+		{"brand:Rogue item:Pair", bar_item, false},
+		{"tag:plates -brand:Rogue", bumper_20kg, true},
+		{"tag:plates -brand:Rogue", plate_45, false},
+		{"-item:/LB/", rack_item, true},
+		// Weights are in pounds, or kg with a suffix
+		{"weight:45", plate_45, true},
+		{"weight:45lb", plate_45, true},
+		{"weight:45", plate_125, false},
+		{"weight:10-45", bumper_20kg, true},
+		{"weight:-10", plate_125, true},
+		{"weight:50-", plate_45, false},
+		{"weight:20kg", bumper_20kg, true},
+		{"weight:20KG", plate_45, false},
+		{"weight:15-25kg", plate_45, true},
+		{"weight:45", rack_item, false},
+		// max is inclusive and needs a known price
+		{"max:$189.99", plate_45, true},
+		{"max:$189.98", plate_45, false},
+		{"max:$300", unknown_item, false},
+		// Triggers and priorities do not affect matching
+		{"brand:Rogue under:$10 drop:50% priority:urgent", bar_item, true},
+	}
+	for _, test := range tests {
+		r, err := Parse(test.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.rule, err)
+			continue
+		}
+		if got := r.Matches(test.item); got != test.want {
+			t.Errorf("%q matching %q = %t, want %t", test.rule, test.item.ID(), got, test.want)
+		}
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	rules, err := ParseLines("test", []string{
+		"brand:Rogue priority:high",
+		"tag:bar priority:2",
+		"tag:plates",
+		"exclude item:1.25LB",
+		"exclude -brand:Rogue weight:20kg",
+		ExcludeItem(combo_item.ID()),
+		"product:Rack",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		item     item.Item
+		watched  bool
+		priority int
+	}{
+		{bar_item, true, 4},
+		{plate_45, true, 4},
+		{plate_125, false, DefaultPriority},
+		{bumper_20kg, false, DefaultPriority},
+		{combo_item, false, DefaultPriority},
+		{rack_item, true, DefaultPriority},
+	}
+	for _, test := range tests {
+		if got := rules.Watched(test.item); got != test.watched {
+			t.Errorf("Watched(%q) = %t, want %t", test.item.ID(), got, test.watched)
+		}
+		if got := rules.Priority(test.item); got != test.priority {
+			t.Errorf("Priority(%q) = %d, want %d", test.item.ID(), got, test.priority)
+		}
+	}
+	if matched := rules.Match(plate_125); len(matched) != 0 {
+		t.Errorf("an excluded item matched %v", matched)
+	}
+}
+
+func TestPriceTrigger(t *testing.T) {
+	tests := []struct {
+		rule       string
+		prev, curr money.Money
+		want       string
+	}{
+		{"brand:Rogue under:$300", money.USD(32500), money.USD(29900), "under $300.00"},
+		{"brand:Rogue under:$300", money.Money{}, money.USD(29900), "under $300.00"},
+		// Only crossing the threshold alerts
+		{"brand:Rogue under:$300", money.USD(29900), money.USD(28900), ""},
+		{"brand:Rogue under:$300", money.USD(32500), money.USD(30000), ""},
+		{"brand:Rogue drop:10%", money.USD(10000), money.USD(8900), "dropped 11%"},
+		{"brand:Rogue drop:10%", money.USD(10000), money.USD(9500), ""},
+		{"brand:Rogue drop:$20", money.USD(10000), money.USD(8000), "dropped $20.00"},
+		{"brand:Rogue drop:$20", money.USD(10000), money.USD(9000), ""},
+		{"brand:Rogue drop:$20", money.Money{}, money.USD(9000), ""},
+		{"brand:Rogue drop:$20", money.USD(10000), money.Money{}, ""},
+		{"brand:Rogue drop:1%", money.USD(10000), money.USD(12000), ""},
+		// Prices in other currencies never compare
+		{"brand:Rogue under:$300", money.USD(32500), money.Money{Cents: 100, Currency: "EUR"}, ""},
+		{"brand:Rogue", money.USD(10000), money.USD(100), ""},
+	}
+	for _, test := range tests {
+		r, err := Parse(test.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", test.rule, err)
+			continue
+		}
+		if got := r.PriceTrigger(test.prev, test.curr); got != test.want {
+			t.Errorf("%q from %s to %s = %q, want %q", test.rule, test.prev, test.curr, got, test.want)
+		}
+	}
+}
+
+func TestPriceChanges(t *testing.T) {
+	rules, err := ParseLines("test", []string{
+		"brand:Rogue",
+		"item:Pair drop:10%",
+		"exclude item:1.25LB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheaper := func(i item.Item, cents int64) item.Item {
+		i.Price = money.USD(cents)
+		return i
+	}
+	items := []item.Item{cheaper(plate_45, 15000), cheaper(plate_125, 100), cheaper(bar_item, 100)}
+	previous := map[string]money.Money{}
+	for _, i := range []item.Item{plate_45, plate_125, bar_item} {
+		previous[i.ID()] = i.Price
+	}
+	changes := rules.PriceChanges(items, previous)
+	if len(changes) != 1 || changes[0].Item.ID() != plate_45.ID() {
+		t.Fatalf("got %v, want just the 45LB plates", changes)
+	}
+	if changes[0].Previous != plate_45.Price || changes[0].Reason != "dropped 21%" {
+		t.Errorf("got %+v", changes[0])
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max float64
+	}{
+		{"45", 45, 45},
+		{"45lb", 45, 45},
+		{"10-45", 10, 45},
+		{"10-", 10, 1e9},
+		{"-45", 0, 45},
+		{"2.5-10LB", 2.5, 10},
+		{"20kg", 44.0924, 44.0924},
+		{"20-25KG", 44.0924, 55.1155},
+	}
+	for _, test := range tests {
+		min, max, err := parseWeight(test.in)
+		if err != nil {
+			t.Errorf("parseWeight(%q): %s", test.in, err)
+			continue
+		}
+		if math.Abs(min-test.min) > 0.001 || math.Abs(max-test.max) > 0.001 {
+			t.Errorf("parseWeight(%q) = %g-%g, want %g-%g", test.in, min, max, test.min, test.max)
+		}
+	}
+	for _, in := range []string{"", "kg", "-", "-lb", "45-10", "ten", "10-20-30"} {
+		if _, _, err := parseWeight(in); err == nil {
+			t.Errorf("parseWeight(%q) should fail", in)
+		}
+	}
+}
+
+func TestParseLinesOldFormat(t *testing.T) {
+	rules, err := ParseLines("watched.txt", []string{
+		"# comment",
+		"",
+		"Rogue Ohio Power Bar :: under $300, drop 10%",
+		`: 1\.25LB Rogue Olympic`,
+		`York Legacy.* (2\.5|5|10)LB`,
+		`Bar/Rack`,
+		`exclude item:Stainless`,
+		`product:"Rep Bumper" -weight:20kg`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"id:/Rogue Ohio Power Bar/ under:$300 drop:10%",
+		`id:/: 1\.25LB Rogue Olympic/`,
+		`id:/York Legacy.* (2\.5|5|10)LB/`,
+		`id:/Bar\/Rack/`,
+		`exclude item:Stainless`,
+		`product:"Rep Bumper" -weight:20kg`,
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for n, r := range rules {
+		if r.Text != want[n] {
+			t.Errorf("rule %d is %q, want %q", n, r.Text, want[n])
+		}
+	}
+
+	old := Rules(rules[:4])
+	matches := []struct {
+		item item.Item
+		want bool
+	}{
+		{york_10, true},
+		{york_25, false},
+		{bar_item, true},
+		{plate_125, false},
+		{combo_item, true},
+	}
+	for _, m := range matches {
+		if got := old.Watched(m.item); got != m.want {
+			t.Errorf("old rules watching %q = %t, want %t", m.item.ID(), got, m.want)
+		}
+	}
+	if rules[0].Under != money.USD(30000) || rules[0].DropPercent != 10 {
+		t.Errorf("old price triggers not converted: %+v", rules[0])
+	}
+}
+
+func TestParseLinesErrors(t *testing.T) {
+	_, err := ParseLines("watched.txt", []string{
+		"brand:Rogue",
+		"Ohio Bar :: under",
+		"item:/(/",
+		"(unclosed",
+	})
+	if err == nil {
+		t.Fatal("want an error")
+	}
+	for _, line := range []string{"watched.txt:2", "watched.txt:3", "watched.txt:4"} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("error does not report %s:\n%s", line, err)
+		}
+	}
+	if strings.Contains(err.Error(), "watched.txt:1") {
+		t.Errorf("error reports a good line:\n%s", err)
+	}
+}
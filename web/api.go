@@ -0,0 +1,249 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/product"
+)
+
+const apiPrefix = "/api/v1/"
+
+type apiItem struct {
+	ID            string `json:"id"`
+	Product       string `json:"product"`
+	Item          string `json:"item"`
+	Brand         string `json:"brand"`
+	Category      string `json:"category"`
+	URL           string `json:"url"`
+	InStock       bool   `json:"in_stock"`
+	Price         string `json:"price"`
+	PriceCents    int64  `json:"price_cents,omitempty"`
+	MaxPriceCents int64  `json:"max_price_cents,omitempty"`
+	WasPriceCents int64  `json:"was_price_cents,omitempty"`
+	Currency      string `json:"currency,omitempty"`
+	Timestamp     string `json:"timestamp"`
+}
+
+type apiRun struct {
+	ID        int64           `json:"id"`
+	Started   string          `json:"started"`
+	Duration  float64         `json:"duration"`
+	Attempted int             `json:"attempted"`
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	Items     int             `json:"items"`
+	Products  []apiRunProduct `json:"products"`
+}
+
+type apiWatch struct {
+	ID        int64  `json:"id"`
+	Rule      string `json:"rule"`
+	Timestamp string `json:"timestamp"`
+}
+
+type apiRunProduct struct {
+	Product  string  `json:"product"`
+	Brand    string  `json:"brand"`
+	Category string  `json:"category"`
+	Items    int     `json:"items"`
+	Error    string  `json:"error,omitempty"`
+	Anomaly  string  `json:"anomaly,omitempty"`
+	Duration float64 `json:"duration"`
+}
+
+// serveAPI routes /api/v1/ requests. Item IDs contain spaces, colons and
+// sometimes slashes, so the history endpoint takes them path escaped.
+func (s *server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), apiPrefix)
+	switch {
+	case path == "products":
+		writeJSON(w, s.products)
+	case path == "items":
+		s.apiItems(w, r)
+	case strings.HasPrefix(path, "items/") && strings.HasSuffix(path, "/history"):
+		id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "items/"), "/history"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad item id: %s", err))
+			return
+		}
+		s.apiHistory(w, id)
+	case path == "runs":
+		s.apiRuns(w, r)
+	case path == "watches":
+		s.apiWatches(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// apiItems lists the latest state of every item, filtered by the brand,
+// product and in_stock query parameters.
+func (s *server) apiItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	brand := query.Get("brand")
+	product_name := query.Get("product")
+	in_stock := query.Get("in_stock")
+	var want_in_stock bool
+	if in_stock != "" {
+		var err error
+		if want_in_stock, err = strconv.ParseBool(in_stock); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad in_stock %q", in_stock))
+			return
+		}
+	}
+
+	items := []apiItem{}
+	for _, row := range database.LatestStock(s.db) {
+		i := s.newAPIItem(row)
+		if brand != "" && !strings.EqualFold(i.Brand, brand) {
+			continue
+		}
+		if product_name != "" && !strings.EqualFold(i.Product, product_name) {
+			continue
+		}
+		if in_stock != "" && i.InStock != want_in_stock {
+			continue
+		}
+		items = append(items, i)
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].ID < items[b].ID })
+	writeJSON(w, items)
+}
+
+// apiHistory lists an item's stock rows, newest first.
+func (s *server) apiHistory(w http.ResponseWriter, id string) {
+	rows := database.QueryItemByID(s.db, id)
+	if len(rows) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no history for %q", id))
+		return
+	}
+	history := []apiItem{}
+	for _, row := range rows {
+		history = append(history, s.newAPIItem(row))
+	}
+	writeJSON(w, history)
+}
+
+// apiRuns lists the most recent runs with their products, up to limit.
+func (s *server) apiRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 500 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("bad limit %q, want 1-500", l))
+			return
+		}
+		limit = n
+	}
+
+	runs := []apiRun{}
+	for _, run := range database.Runs(s.db, limit) {
+		a := apiRun{
+			ID:        run.ID,
+			Started:   run.Started,
+			Duration:  run.Duration,
+			Attempted: run.Attempted,
+			Succeeded: run.Succeeded,
+			Failed:    run.Failed,
+			Items:     run.Items,
+			Products:  []apiRunProduct{},
+		}
+		for _, p := range database.RunProducts(s.db, run.ID) {
+			a.Products = append(a.Products, apiRunProduct{
+				Product:  p.ProductName,
+				Brand:    p.Brand,
+				Category: p.Category,
+				Items:    p.Items,
+				Error:    p.Error,
+				Anomaly:  p.Anomaly,
+				Duration: p.Duration,
+			})
+		}
+		runs = append(runs, a)
+	}
+	writeJSON(w, runs)
+}
+
+// apiWatches lists a chat's watches, given by the chat parameter. There is
+// no listing of every chat's, as that would give away who uses the bot.
+func (s *server) apiWatches(w http.ResponseWriter, r *http.Request) {
+	chat := r.URL.Query().Get("chat")
+	if chat == "" {
+		writeError(w, http.StatusBadRequest, "missing chat")
+		return
+	}
+	chat_id, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad chat %q", chat))
+		return
+	}
+
+	watches := []apiWatch{}
+	for _, row := range database.Watches(s.db, chat_id) {
+		watches = append(watches, apiWatch{
+			ID:        row.ID,
+			Rule:      row.Rule,
+			Timestamp: row.Timestamp,
+		})
+	}
+	writeJSON(w, watches)
+}
+
+func (s *server) newAPIItem(row database.StockRow) apiItem {
+	i := apiItem{
+		ID:            row.ID(),
+		Product:       row.ProductName,
+		Item:          row.ItemName,
+		InStock:       row.InStock,
+		Price:         row.Price,
+		PriceCents:    row.PriceCents,
+		MaxPriceCents: row.MaxPriceCents,
+		WasPriceCents: row.WasPriceCents,
+		Currency:      row.Currency,
+		Timestamp:     row.Timestamp,
+	}
+	if p, ok := s.product(row.ProductName); ok {
+		i.Brand = p.Brand
+		i.Category = p.Category
+		i.URL = p.URL
+	}
+	return i
+}
+
+func (s *server) product(name string) (product.Product, bool) {
+	for _, p := range s.products {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return product.Product{}, false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
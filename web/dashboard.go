@@ -0,0 +1,172 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/item"
+	"github.com/maxtrussell/gym-stock-bot/watch"
+)
+
+// refreshSeconds is how often the dashboard reloads itself
+const refreshSeconds = 60
+
+type dashboard struct {
+	Refresh     int
+	LastRun     string
+	WatchedOnly bool
+	InStockOnly bool
+	Vendors     []dashboardVendor
+}
+
+type dashboardVendor struct {
+	Name     string
+	Products []dashboardProduct
+}
+
+type dashboardProduct struct {
+	Name  string
+	URL   string
+	Items []dashboardItem
+}
+
+type dashboardItem struct {
+	ID          string
+	Link        string
+	Name        string
+	InStock     bool
+	Watched     bool
+	Price       string
+	LastInStock string
+	Changed     string
+}
+
+// renderDashboard shows the latest state of every tracked product, grouped
+// by vendor. The watched and in_stock query parameters filter items.
+func (s *server) renderDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	d := dashboard{
+		Refresh:     refreshSeconds,
+		LastRun:     "never",
+		WatchedOnly: r.URL.Query().Get("watched") != "",
+		InStockOnly: r.URL.Query().Get("in_stock") != "",
+	}
+	if runs := database.Runs(s.db, 1); len(runs) > 0 {
+		d.LastRun = runs[0].Started
+	}
+
+	rules := s.chatRules()
+	latest := database.LatestStock(s.db)
+	last_in_stock := database.LastInStock(s.db)
+	vendors := map[string]*dashboardVendor{}
+	var names []string
+	for n := range s.products {
+		p := &s.products[n]
+		var rows []database.StockRow
+		for _, row := range latest {
+			if row.ProductName == p.Name {
+				rows = append(rows, row)
+			}
+		}
+		sort.Slice(rows, func(a, b int) bool { return rows[a].ItemName < rows[b].ItemName })
+
+		dp := dashboardProduct{Name: p.Name, URL: p.URL}
+		for _, row := range rows {
+			i := row.Item(p)
+			di := dashboardItem{
+				ID:          row.ID(),
+				Link:        itemLink(row.ID()),
+				Name:        row.ItemName,
+				InStock:     row.InStock,
+				Watched:     watched(rules, i),
+				Price:       i.PriceString(),
+				LastInStock: "never",
+				Changed:     ago(now, row.Timestamp),
+			}
+			if t, ok := last_in_stock[row.ID()]; ok {
+				di.LastInStock = t.Local().Format("Jan 02 15:04")
+			}
+			if (d.WatchedOnly && !di.Watched) || (d.InStockOnly && !di.InStock) {
+				continue
+			}
+			dp.Items = append(dp.Items, di)
+		}
+		if len(dp.Items) == 0 && (d.WatchedOnly || d.InStockOnly) {
+			continue
+		}
+
+		v, ok := vendors[p.Brand]
+		if !ok {
+			v = &dashboardVendor{Name: p.Brand}
+			vendors[p.Brand] = v
+			names = append(names, p.Brand)
+		}
+		v.Products = append(v.Products, dp)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.Vendors = append(d.Vendors, *vendors[name])
+	}
+
+	t, err := template.ParseFiles("index.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.Execute(w, d); err != nil {
+		log.Println(err)
+	}
+}
+
+// chatRules returns each subscriber's watch rules, skipping chats with
+// invalid rules.
+func (s *server) chatRules() []watch.Rules {
+	var rules []watch.Rules
+	for _, chat_id := range database.Subscribers(s.db) {
+		chat_rules, err := watch.LoadStored(s.db, chat_id)
+		if err != nil {
+			log.Printf("Skipping chat %d: %s\n", chat_id, err)
+			continue
+		}
+		rules = append(rules, chat_rules)
+	}
+	return rules
+}
+
+// watched reports whether any chat watches i
+func watched(rules []watch.Rules, i item.Item) bool {
+	for _, r := range rules {
+		if r.Watched(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// ago formats the time since a local db timestamp, e.g. "3d 4h ago"
+func ago(now time.Time, timestamp string) string {
+	t, err := time.ParseInLocation(database.TimeFormat, timestamp, time.Local)
+	if err != nil {
+		return "unknown"
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dm ago", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd %dh ago", int(d.Hours())/24, int(d.Hours())%24)
+}
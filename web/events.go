@@ -0,0 +1,120 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/database"
+)
+
+const (
+	eventPollInterval = time.Second
+	eventHeartbeat    = 15 * time.Second
+	eventBatch        = 100
+)
+
+type apiEvent struct {
+	ID         int64  `json:"id"`
+	Type       string `json:"type"`
+	ItemID     string `json:"item_id"`
+	Product    string `json:"product"`
+	Item       string `json:"item"`
+	OldInStock *bool  `json:"old_in_stock,omitempty"`
+	NewInStock bool   `json:"new_in_stock"`
+	OldPrice   string `json:"old_price,omitempty"`
+	NewPrice   string `json:"new_price"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// serveEvents streams stock transitions as server-sent events. A client
+// resuming with Last-Event-ID, or the last_event_id query parameter, gets
+// every event after that one; otherwise only new events are sent.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	last_id := database.LastEventID(s.db)
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("last_event_id")
+	}
+	if resume != "" {
+		id, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, fmt.Sprintf("bad last event id %q", resume), http.StatusBadRequest)
+			return
+		}
+		last_id = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventPollInterval.Milliseconds()*3)
+	flusher.Flush()
+
+	poll := time.NewTicker(eventPollInterval)
+	defer poll.Stop()
+	last_write := time.Now()
+	for {
+		events := database.EventsSince(s.db, last_id, eventBatch)
+		for _, e := range events {
+			data, err := json.Marshal(newAPIEvent(e))
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			last_id = e.ID
+		}
+		if len(events) > 0 {
+			flusher.Flush()
+			last_write = time.Now()
+			if len(events) == eventBatch {
+				// Catch up without waiting
+				continue
+			}
+		} else if time.Since(last_write) >= eventHeartbeat {
+			// Keep idle connections from being closed by proxies
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			last_write = time.Now()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case <-poll.C:
+		}
+	}
+}
+
+func newAPIEvent(e database.Event) apiEvent {
+	a := apiEvent{
+		ID:         e.ID,
+		Type:       e.Type,
+		ItemID:     e.ItemID(),
+		Product:    e.ProductName,
+		Item:       e.ItemName,
+		NewInStock: e.NewInStock,
+		OldPrice:   e.OldPrice,
+		NewPrice:   e.NewPrice,
+		Timestamp:  e.Timestamp,
+	}
+	if e.OldInStock.Valid {
+		a.OldInStock = &e.OldInStock.Bool
+	}
+	return a
+}
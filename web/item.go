@@ -0,0 +1,209 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/analytics"
+	"github.com/maxtrussell/gym-stock-bot/database"
+	"github.com/maxtrussell/gym-stock-bot/models/money"
+)
+
+const itemPrefix = "/items/"
+
+// Chart dimensions, in svg units
+const (
+	chartWidth     = 800
+	chartPadding   = 60
+	timelineHeight = 30
+	priceHeight    = 200
+)
+
+type itemPage struct {
+	Width      int
+	Height     int
+	ID         string
+	URL        string
+	Found      bool
+	Stats      analytics.Stats
+	Timeline   []timelineBar
+	TimeLabels [2]string
+	Prices     *priceChart
+	Rows       []database.StockRow
+}
+
+type timelineBar struct {
+	X, Width float64
+	InStock  bool
+	Title    string
+}
+
+type priceChart struct {
+	Points   string
+	Dots     []chartDot
+	MinLabel string
+	MaxLabel string
+	MinY     float64
+	MaxY     float64
+	Height   float64
+}
+
+type chartDot struct {
+	X, Y  float64
+	Title string
+}
+
+// itemLink is the history page of an item.
+func itemLink(id string) string {
+	return itemPrefix + url.PathEscape(id)
+}
+
+// renderItem shows an item's stock timeline, price chart and stats. The
+// item ID is path escaped, as it holds spaces and sometimes slashes.
+func (s *server) renderItem(w http.ResponseWriter, r *http.Request) {
+	id, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), itemPrefix))
+	if err != nil {
+		http.Error(w, "bad item id", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	rows := database.QueryItemByID(s.db, id)
+	page := itemPage{Width: chartWidth, Height: timelineHeight, ID: id, Rows: rows}
+	page.Stats, page.Found, err = analytics.Compute(id, rows, now)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad stock history", http.StatusInternalServerError)
+		return
+	}
+	if page.Found {
+		if p, ok := s.product(rows[0].ProductName); ok {
+			page.URL = p.URL
+		}
+		intervals, err := analytics.Intervals(rows, now)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad stock history", http.StatusInternalServerError)
+			return
+		}
+		points, err := analytics.Prices(rows)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad stock history", http.StatusInternalServerError)
+			return
+		}
+		start := intervals[0].Start
+		page.Timeline = timeline(intervals, start, now)
+		page.TimeLabels = [2]string{start.Format("Jan 02 15:04"), now.Format("Jan 02 15:04")}
+		page.Prices = prices(points, start, now)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	funcs := template.FuncMap{"duration": analytics.FormatDuration}
+	t, err := template.New("item.html").Funcs(funcs).ParseFiles("item.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.Execute(w, page); err != nil {
+		log.Println(err)
+	}
+}
+
+// xPos maps t into the chart's horizontal range
+func xPos(t, start, end time.Time) float64 {
+	span := end.Sub(start).Seconds()
+	if span <= 0 {
+		return chartPadding
+	}
+	return chartPadding + (chartWidth-2*chartPadding)*t.Sub(start).Seconds()/span
+}
+
+func timeline(intervals []analytics.Interval, start, end time.Time) []timelineBar {
+	var bars []timelineBar
+	for _, i := range intervals {
+		state := "Out of stock"
+		if i.InStock {
+			state = "In stock"
+		}
+		x := xPos(i.Start, start, end)
+		bars = append(bars, timelineBar{
+			X:       x,
+			Width:   xPos(i.End, start, end) - x,
+			InStock: i.InStock,
+			Title: fmt.Sprintf(
+				"%s %s - %s (%s)",
+				state,
+				i.Start.Format("Jan 02 15:04"),
+				i.End.Format("Jan 02 15:04"),
+				analytics.FormatDuration(i.End.Sub(i.Start)),
+			),
+		})
+	}
+	return bars
+}
+
+// prices draws a step chart of prices, or nil if there are none. Prices in
+// another currency than the latest are left out.
+func prices(points []analytics.PricePoint, start, end time.Time) *priceChart {
+	if len(points) == 0 {
+		return nil
+	}
+	currency := points[len(points)-1].Price.Currency
+	var kept []analytics.PricePoint
+	for _, p := range points {
+		if p.Price.Currency == currency {
+			kept = append(kept, p)
+		}
+	}
+	low, high := kept[0].Price.Cents, kept[0].Price.Cents
+	for _, p := range kept {
+		if p.Price.Cents < low {
+			low = p.Price.Cents
+		}
+		if p.Price.Cents > high {
+			high = p.Price.Cents
+		}
+	}
+	if low == high {
+		// Give a flat line some room
+		low -= 100
+		high += 100
+	}
+	top, bottom := 10.0, float64(priceHeight-20)
+	yPos := func(cents int64) float64 {
+		return bottom - (bottom-top)*float64(cents-low)/float64(high-low)
+	}
+
+	c := &priceChart{
+		MinLabel: money.Money{Cents: low, Currency: currency}.String(),
+		MaxLabel: money.Money{Cents: high, Currency: currency}.String(),
+		MinY:     bottom,
+		MaxY:     top,
+		Height:   priceHeight,
+	}
+	var coords []string
+	for n, p := range kept {
+		x, y := xPos(p.Time, start, end), yPos(p.Price.Cents)
+		if n > 0 {
+			// Hold the previous price until this change
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, yPos(kept[n-1].Price.Cents)))
+		}
+		coords = append(coords, fmt.Sprintf("%.1f,%.1f", x, y))
+		c.Dots = append(c.Dots, chartDot{
+			X:     x,
+			Y:     y,
+			Title: fmt.Sprintf("%s on %s", p.Price, p.Time.Format("Jan 02 15:04")),
+		})
+	}
+	last := kept[len(kept)-1]
+	coords = append(coords, fmt.Sprintf("%.1f,%.1f", xPos(end, start, end), yPos(last.Price.Cents)))
+	c.Points = strings.Join(coords, " ")
+	return c
+}
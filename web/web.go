@@ -1,43 +1,55 @@
 package web
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"html/template"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/maxtrussell/gym-stock-bot/models/product"
 )
 
-func ListenAndServe() {
-	fileServer := http.FileServer(http.Dir("."))
-	http.HandleFunc("/", renderIndex)
-	http.Handle("/files/", http.StripPrefix("/files/", fileServer))
-	if err := http.ListenAndServe("0.0.0.0:6004", nil); err != nil {
-		fmt.Println("HERE")
-		log.Fatal(err)
-	}
+type server struct {
+	// ctx ends long lived responses such as event streams on shutdown
+	ctx      context.Context
+	db       *sql.DB
+	products []product.Product
 }
 
-func renderIndex(w http.ResponseWriter, r *http.Request) {
-	files := []string{}
+// ListenAndServe serves until ctx is cancelled, then shuts down gracefully.
+func ListenAndServe(ctx context.Context, db *sql.DB, products []product.Product) {
+	s := &server{ctx: ctx, db: db, products: products}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.renderDashboard)
+	mux.HandleFunc("/files/", serveLog)
+	mux.HandleFunc(itemPrefix, s.renderItem)
+	mux.HandleFunc(apiPrefix, s.serveAPI)
+	mux.HandleFunc("/events", s.serveEvents)
+	server := &http.Server{Addr: "0.0.0.0:6004", Handler: mux}
 
-	dirContents, err := ioutil.ReadDir(".")
-	if err != nil {
-		log.Fatal(err)
-	}
-	for _, file := range dirContents {
-		if strings.HasSuffix(file.Name(), ".txt") {
-			files = append(files, file.Name())
+	go func() {
+		<-ctx.Done()
+		shutdown_ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdown_ctx); err != nil {
+			fmt.Printf("Web server shutdown: %s\n", err)
 		}
-	}
-	vars := struct{ Files []string }{Files: files}
-
-	parsedTemplate, err := template.ParseFiles("index.html")
-	if err != nil {
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
-	if err = parsedTemplate.Execute(w, vars); err != nil {
-		log.Fatal(err)
+}
+
+// serveLog serves the .txt logs in the working directory, and nothing else
+// there, as it also holds the db.
+func serveLog(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/files/")
+	if !strings.HasSuffix(name, ".txt") || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
 	}
+	http.ServeFile(w, r, name)
 }